@@ -0,0 +1,202 @@
+// accesslog
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vsdutka/iplsgo/otasker"
+)
+
+// Формат строк лога запросов (Http.LogFormat)
+const (
+	logFormatLegacy   = "legacy"
+	logFormatCombined = "combined"
+	logFormatJSON     = "json"
+)
+
+type accessLogEntry struct {
+	Time       time.Time
+	Request    *http.Request
+	Status     int
+	Length     int
+	Duration   time.Duration
+	Handler    string
+	SessionID  string
+	Proc       string
+	DBDuration time.Duration
+	RequestID  string
+}
+
+// accessLogRecord - строка лога в формате json
+type accessLogRecord struct {
+	Time          string  `json:"time"`
+	RemoteAddr    string  `json:"remote_addr"`
+	User          string  `json:"user,omitempty"`
+	Method        string  `json:"method"`
+	Host          string  `json:"host"`
+	URL           string  `json:"url"`
+	Proto         string  `json:"proto"`
+	Status        int     `json:"status"`
+	Bytes         int     `json:"bytes"`
+	RequestLength int64   `json:"request_length"`
+	Duration      float64 `json:"duration_ms"`
+	Referer       string  `json:"referer,omitempty"`
+	UserAgent     string  `json:"user_agent,omitempty"`
+	Handler       string  `json:"handler,omitempty"`
+	SessionID     string  `json:"session_id,omitempty"`
+	Proc          string  `json:"proc,omitempty"`
+	DBDuration    float64 `json:"db_duration_ms,omitempty"`
+	RequestID     string  `json:"request_id,omitempty"`
+}
+
+func validLogFormat(format string) bool {
+	switch format {
+	case "", logFormatLegacy, logFormatCombined, logFormatJSON:
+		return true
+	}
+	return false
+}
+
+// accessLogFormat возвращает формат лога запросов и признак вывода дополнительных полей
+func accessLogFormat() (string, bool) {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return confHTTPLogFormat, confHTTPLogExtra
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatAccessLog(format string, extra bool, e *accessLogEntry) string {
+	r := e.Request
+	user, _, ok := r.BasicAuth()
+	if !ok {
+		user = "-"
+	}
+	url := r.URL.Path
+	if params := encodeLogParams(r.Form); params != "" {
+		url = url + "?" + params
+	}
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	var line string
+	switch format {
+	case logFormatJSON:
+		rec := accessLogRecord{
+			Time:          e.Time.Format(time.RFC3339Nano),
+			RemoteAddr:    clientIP(r),
+			Method:        r.Method,
+			Host:          r.Host,
+			URL:           url,
+			Proto:         r.Proto,
+			Status:        status,
+			Bytes:         e.Length,
+			RequestLength: r.ContentLength,
+			Duration:      milliseconds(e.Duration),
+			Referer:       r.Referer(),
+			UserAgent:     r.UserAgent(),
+			Handler:       e.Handler,
+			SessionID:     e.SessionID,
+			Proc:          e.Proc,
+			DBDuration:    milliseconds(e.DBDuration),
+			RequestID:     e.RequestID,
+		}
+		if ok {
+			rec.User = user
+		}
+		buf, err := json.Marshal(rec)
+		if err != nil {
+			return ""
+		}
+		return string(buf) + "\n"
+	case logFormatCombined:
+		bytes := "-"
+		if e.Length > 0 {
+			bytes = strconv.Itoa(e.Length)
+		}
+		line = fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"",
+			clientIP(r),
+			user,
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method,
+			escapeLogValue(url),
+			r.Proto,
+			status,
+			bytes,
+			escapeLogValue(r.Referer()),
+			escapeLogValue(r.UserAgent()),
+		)
+	default:
+		line = fmt.Sprintf("%s, %20s, %s, %s, %12d, %12d, %8d, %d, %s, %s, %v",
+			r.RemoteAddr,
+			user,
+			e.Time.Format("2006.01.02"),
+			e.Time.Format("15:04:05.000000000"),
+			e.Length,
+			r.ContentLength,
+			e.Duration/time.Millisecond,
+			e.Status,
+			r.Method,
+			url,
+			e.Duration,
+		)
+	}
+	if extra {
+		line += fmt.Sprintf(" handler=\"%s\" session=\"%s\" proc=\"%s\" db_ms=%.3f request_id=\"%s\"",
+			escapeLogValue(e.Handler), escapeLogValue(e.SessionID), escapeLogValue(e.Proc), milliseconds(e.DBDuration),
+			escapeLogValue(e.RequestID))
+	}
+	if format == logFormatCombined {
+		return line + "\n"
+	}
+	return line + "\r\n"
+}
+
+// encodeLogParams кодирует параметры запроса так же, как url.Values.Encode, но со скрытыми значениями
+// параметров, имена которых заданы в Http.RedactParams
+func encodeLogParams(v url.Values) string {
+	if len(v) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		redacted := otasker.Redacted(k)
+		key := url.QueryEscape(k)
+		for _, val := range v[k] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(key)
+			buf.WriteByte('=')
+			if redacted {
+				buf.WriteString(otasker.RedactedValue)
+			} else {
+				buf.WriteString(url.QueryEscape(val))
+			}
+		}
+	}
+	return buf.String()
+}
+
+var logValueReplacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\r", "\\r", "\n", "\\n")
+
+func escapeLogValue(v string) string {
+	return logValueReplacer.Replace(v)
+}
@@ -0,0 +1,75 @@
+// accesslog_test
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vsdutka/iplsgo/otasker"
+)
+
+func TestFormatAccessLog(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ti8/p?a=1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("User-Agent", "Mozilla \"5.0\"")
+	r.SetBasicAuth("user", "pass")
+	r.ParseForm()
+	e := &accessLogEntry{
+		Time:       time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+		Request:    r,
+		Status:     404,
+		Length:     120,
+		Duration:   1500 * time.Millisecond,
+		Handler:    "/ti8",
+		SessionID:  "abc",
+		Proc:       "p",
+		DBDuration: 1200 * time.Millisecond,
+		RequestID:  "r1",
+	}
+
+	var tests = []struct {
+		format string
+		extra  bool
+		want   string
+	}{
+		{logFormatLegacy, false, "10.0.0.1:1234,                 user, 2018.01.02, 03:04:05.000000000,          120,            0,     1500, 404, GET, /ti8/p?a=1, 1.5s\r\n"},
+		{"", true, "10.0.0.1:1234,                 user, 2018.01.02, 03:04:05.000000000,          120,            0,     1500, 404, GET, /ti8/p?a=1, 1.5s handler=\"/ti8\" session=\"abc\" proc=\"p\" db_ms=1200.000 request_id=\"r1\"\r\n"},
+		{logFormatCombined, false, "10.0.0.1 - user [02/Jan/2018:03:04:05 +0000] \"GET /ti8/p?a=1 HTTP/1.1\" 404 120 \"\" \"Mozilla \\\"5.0\\\"\"\n"},
+	}
+	for _, v := range tests {
+		if got := formatAccessLog(v.format, v.extra, e); got != v.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", v.format, got, v.want)
+		}
+	}
+
+	line := formatAccessLog(logFormatJSON, false, e)
+	if !strings.HasSuffix(line, "}\n") || strings.Count(line, "\n") != 1 {
+		t.Fatalf("JSON line should be terminated by single newline: %q", line)
+	}
+	var rec accessLogRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.RemoteAddr != "10.0.0.1" || rec.User != "user" || rec.URL != "/ti8/p?a=1" || rec.Status != 404 ||
+		rec.Bytes != 120 || rec.Duration != 1500 || rec.Handler != "/ti8" || rec.Proc != "p" || rec.DBDuration != 1200 || rec.RequestID != "r1" {
+		t.Errorf("Got %+v", rec)
+	}
+}
+
+func TestEncodeLogParams(t *testing.T) {
+	otasker.SetRedactedParams([]string{"*pass*"})
+	defer otasker.SetRedactedParams(nil)
+
+	r := httptest.NewRequest("GET", "/ti8/p?p_user=admin&p_password=s%26cret&p_user=a%20b&P_PASS2=x", nil)
+	r.ParseForm()
+	want := "P_PASS2=" + otasker.RedactedValue + "&p_password=" + otasker.RedactedValue + "&p_user=admin&p_user=a+b"
+	if got := encodeLogParams(r.Form); got != want {
+		t.Errorf("Got \"%s\", want \"%s\"", got, want)
+	}
+	if got := encodeLogParams(nil); got != "" {
+		t.Errorf("Got \"%s\" for empty params", got)
+	}
+}
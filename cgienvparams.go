@@ -7,10 +7,11 @@ import (
 )
 
 func makeEnvParams(req *http.Request, docTab, remoteUser, mirrorPath string) map[string]string {
+	fwd := forwarded(req)
 	https := "N"
 	portSequre := "0"
 
-	if req.TLS != nil {
+	if fwd.https {
 		https = "Y"
 		portSequre = "1"
 	}
@@ -23,25 +24,18 @@ func makeEnvParams(req *http.Request, docTab, remoteUser, mirrorPath string) map
 		protocol += "S"
 	}
 
-	host := ""
-	port := ""
-
-	for k, v := range strings.Split(req.Host, ":") {
-		switch {
-		case k == 0:
-			host = v
-		case k == 1:
-			port = v
-		}
+	host, port := fwd.host, fwd.port
+	if info := getRequestInfo(req); info != nil && info.vhost != "" {
+		host = info.vhost
 	}
 
-	return map[string]string{
+	res := map[string]string{
 		"APEX_LISTENER_VERSION": "2",
 		"SERVER_SOFTWARE":       "iPLSQL",
 		"SERVER_NAME":           host,
 		"GATEWAY_INTERFACE":     "CGI/1.1",
-		"REMOTE_HOST":           req.RemoteAddr,
-		"REMOTE_ADDR":           req.RemoteAddr,
+		"REMOTE_HOST":           fwd.addr,
+		"REMOTE_ADDR":           fwd.addr,
 		"AUTH_TYPE":             req.Header.Get("Authorization"),
 		"REMOTE_USER":           remoteUser,
 		"REMOTE_IDENT":          remoteUser,
@@ -64,10 +58,6 @@ func makeEnvParams(req *http.Request, docTab, remoteUser, mirrorPath string) map
 		"QUERY_STRING":          req.URL.RawQuery,
 		"HTTPS":                 https,
 		"SERVER_PORT_SECURE":    portSequre,
-		"HTTPS_SESSIONID":       req.Header.Get("HTTPS_SESSIONID"),
-		"HTTPS_KEYSIZE":         req.Header.Get("HTTPS_KEYSIZE"),
-		"HTTPS_SERVER_ISSUER":   req.Header.Get("HTTPS_SERVER_ISSUER"),
-		"HTTPS_SERVER_SUBJECT":  req.Header.Get("HTTPS_SERVER_SUBJECT"),
 		"cookie":                req.Header.Get("Cookie"),
 		"user-agent":            req.Header.Get("User-Agent"),
 		"referer":               req.Header.Get("Referer"),
@@ -82,5 +72,10 @@ func makeEnvParams(req *http.Request, docTab, remoteUser, mirrorPath string) map
 		"REQUEST_SCHEME":        protocol,
 		"AUTHORIZATION":         req.Header.Get("Authorization"),
 		"MIRROR_PATH":           mirrorPath,
+		"REQUEST_ID":            getRequestID(req),
+	}
+	for k, v := range tlsEnvParams(req) {
+		res[k] = v
 	}
+	return res
 }
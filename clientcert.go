@@ -0,0 +1,189 @@
+// clientcert
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Режимы проверки клиентских сертификатов (Http.SSLClientAuth)
+const (
+	clientAuthNone    = "none"
+	clientAuthRequest = "request"
+	clientAuthRequire = "require"
+)
+
+// Поля сертификата, по которым определяется пользователь (owa.ClientCertUser)
+const (
+	certUserCN    = "CN"
+	certUserEmail = "email"
+	certUserDNS   = "dns"
+)
+
+var (
+	clientAuthLock sync.RWMutex
+	clientAuth     tls.ClientAuthType
+	clientCAs      *x509.CertPool
+)
+
+func parseClientAuth(mode, caPEM string) (tls.ClientAuthType, *x509.CertPool, error) {
+	var authType tls.ClientAuthType
+	switch strings.ToLower(mode) {
+	case "", clientAuthNone:
+		return tls.NoClientCert, nil, nil
+	case clientAuthRequest:
+		authType = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		authType = tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert, nil, errors.New("unknown client certificate mode \"" + mode + "\"")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		return tls.NoClientCert, nil, errors.New("no CA certificates found in Http.SSLClientCA")
+	}
+	return authType, pool, nil
+}
+
+// setClientAuth задает проверку клиентских сертификатов для новых TLS соединений
+func setClientAuth(mode, caPEM string) error {
+	authType, pool, err := parseClientAuth(mode, caPEM)
+	if err != nil {
+		return err
+	}
+	clientAuthLock.Lock()
+	clientAuth, clientCAs = authType, pool
+	clientAuthLock.Unlock()
+	return nil
+}
+
+// clientCertUser возвращает имя пользователя из проверенного клиентского сертификата
+func clientCertUser(r *http.Request, field string) string {
+	if field == "" || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	switch {
+	case strings.EqualFold(field, certUserCN):
+		return cert.Subject.CommonName
+	case strings.EqualFold(field, certUserEmail):
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case strings.EqualFold(field, certUserDNS):
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	}
+	return ""
+}
+
+func validCertUserField(field string) bool {
+	switch strings.ToLower(field) {
+	case "", strings.ToLower(certUserCN), certUserEmail, certUserDNS:
+		return true
+	}
+	return false
+}
+
+// tlsHeaderPrefix - префикс заголовков, которыми доверенный прокси, завершающий TLS, передает параметры соединения:
+// X-Tls-Session-Id, X-Tls-Key-Size, X-Tls-Server-Issuer, X-Tls-Server-Subject
+const tlsHeaderPrefix = "X-Tls-"
+
+// tlsProxyHeaders - соответствие переменных CGI окружения заголовкам доверенного прокси
+var tlsProxyHeaders = map[string]string{
+	"HTTPS_SESSIONID":      tlsHeaderPrefix + "Session-Id",
+	"HTTPS_KEYSIZE":        tlsHeaderPrefix + "Key-Size",
+	"HTTPS_SERVER_ISSUER":  tlsHeaderPrefix + "Server-Issuer",
+	"HTTPS_SERVER_SUBJECT": tlsHeaderPrefix + "Server-Subject",
+}
+
+// tlsEnvParams возвращает параметры TLS соединения для CGI окружения. Без TLS параметры берутся
+// из заголовков tlsProxyHeaders, только если запрос пришел от доверенного прокси (Http.TrustedProxies)
+func tlsEnvParams(req *http.Request) map[string]string {
+	res := make(map[string]string, len(tlsProxyHeaders))
+	if req.TLS == nil {
+		trusted := fromTrustedProxy(req)
+		for k, v := range tlsProxyHeaders {
+			res[k] = ""
+			if trusted {
+				res[k] = req.Header.Get(v)
+			}
+		}
+		return res
+	}
+	cs := req.TLS
+
+	res["HTTPS_SESSIONID"] = tlsSessionID(cs)
+	res["HTTPS_KEYSIZE"] = strconv.Itoa(cipherKeySize(cs.CipherSuite))
+	res["HTTPS_CIPHER"] = tls.CipherSuiteName(cs.CipherSuite)
+	res["HTTPS_PROTOCOL"] = tlsVersionName(cs.Version)
+	if cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: cs.ServerName}); err == nil && cert.Leaf != nil {
+		res["HTTPS_SERVER_ISSUER"] = cert.Leaf.Issuer.String()
+		res["HTTPS_SERVER_SUBJECT"] = cert.Leaf.Subject.String()
+	}
+	if len(cs.PeerCertificates) > 0 {
+		cert := cs.PeerCertificates[0]
+		res["CERT_SUBJECT"] = cert.Subject.String()
+		res["CERT_ISSUER"] = cert.Issuer.String()
+		res["CERT_SERIALNUMBER"] = cert.SerialNumber.Text(16)
+		if len(cs.VerifiedChains) > 0 {
+			res["CERT_VERIFIED"] = "Y"
+		} else {
+			res["CERT_VERIFIED"] = "N"
+		}
+	}
+	return res
+}
+
+// tlsSessionLabel - метка RFC 5705 для получения идентификатора TLS сессии
+const tlsSessionLabel = "EXPERIMENTAL iplsgo session id"
+
+// tlsSessionID возвращает идентификатор TLS соединения. В TLS 1.3 значение tls-unique отсутствует,
+// поэтому идентификатор получается из ключевого материала соединения (RFC 5705, RFC 8446)
+func tlsSessionID(cs *tls.ConnectionState) string {
+	if len(cs.TLSUnique) != 0 {
+		return hex.EncodeToString(cs.TLSUnique)
+	}
+	id, err := cs.ExportKeyingMaterial(tlsSessionLabel, nil, 32)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return ""
+}
+
+// cipherKeySize возвращает размер ключа симметричного шифра набора cs в битах
+func cipherKeySize(cs uint16) int {
+	name := tls.CipherSuiteName(cs)
+	switch {
+	case strings.Contains(name, "AES_128"):
+		return 128
+	case strings.Contains(name, "AES_256"), strings.Contains(name, "CHACHA20"):
+		return 256
+	case strings.Contains(name, "3DES"):
+		return 168
+	case strings.Contains(name, "RC4_128"):
+		return 128
+	}
+	return 0
+}
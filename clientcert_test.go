@@ -0,0 +1,180 @@
+// clientcert_test
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func makeTestCert(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (string, string, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+		cert, key
+}
+
+func TestClientCert(t *testing.T) {
+	caPEM, _, caCert, caKey := makeTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	serverPEM, serverKeyPEM, _, _ := makeTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+	clientPEM, clientKeyPEM, _, _ := makeTestCert(t, &x509.Certificate{
+		SerialNumber:   big.NewInt(3),
+		Subject:        pkix.Name{CommonName: "USER1"},
+		EmailAddresses: []string{"user1@example.com"},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, caKey)
+
+	if err := setCertificates([]certSource{{Cert: serverPEM, Key: serverKeyPEM}}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		certLock.Lock()
+		certs, certSources = nil, nil
+		certLock.Unlock()
+	}()
+	if err := setClientAuth(clientAuthRequire, caPEM); err != nil {
+		t.Fatal(err)
+	}
+	defer setClientAuth("", "")
+
+	tlsConfig := &tls.Config{GetCertificate: getCertificate}
+	tlsConfig.GetConfigForClient = tlsConfigForClient(tlsConfig)
+	s := &httpListener{
+		name:      "Test",
+		tlsConfig: tlsConfig,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			env := makeEnvParams(r, "", "", "")
+			w.Write([]byte(clientCertUser(r, certUserCN) + "|" + clientCertUser(r, certUserEmail) + "|" +
+				env["CERT_SUBJECT"] + "|" + env["HTTPS_SERVER_SUBJECT"] + "|" + env["HTTPS"] + "|" +
+				env["HTTPS_PROTOCOL"] + " " + strconv.Itoa(len(env["HTTPS_SESSIONID"]))))
+		}),
+	}
+	port := freePort(t)
+	if err := s.apply(port, true, time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer s.apply(0, false, 0, 0)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(caPEM))
+	clientCert, err := tls.X509KeyPair([]byte(clientPEM), []byte(clientKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(certs []tls.Certificate) (string, error) {
+		client := http.Client{
+			Timeout: time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				Certificates: certs,
+			}},
+		}
+		resp, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		return string(buf), err
+	}
+
+	body, err := get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// В TLS 1.3 идентификатор сессии получается из ключевого материала соединения
+	if want := "USER1|user1@example.com|CN=USER1|CN=server|Y|TLSv1.3 64"; body != want {
+		t.Errorf("got \"%s\", want \"%s\"", body, want)
+	}
+	if _, err := get(nil); err == nil {
+		t.Error("Request without client certificate should fail")
+	}
+
+	if _, _, err := parseClientAuth("optional", caPEM); err == nil {
+		t.Error("Unknown mode should be error")
+	}
+	if _, _, err := parseClientAuth(clientAuthRequest, ""); err == nil {
+		t.Error("Empty CA should be error")
+	}
+}
+
+func TestTLSEnvParams(t *testing.T) {
+	proxies, _ := parseIPList([]string{"10.0.0.0/8"})
+	confLock.Lock()
+	confTrustedProxies = proxies
+	confLock.Unlock()
+	defer func() {
+		confLock.Lock()
+		confTrustedProxies = nil
+		confLock.Unlock()
+	}()
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"plain", "192.168.1.1:1234", map[string]string{"HTTPS_SESSIONID": "fake"}, ""},
+		{"untrusted", "192.168.1.1:1234", map[string]string{"X-Tls-Session-Id": "fake"}, ""},
+		{"trusted raw name", "10.0.0.1:1234", map[string]string{"HTTPS_SESSIONID": "fake"}, ""},
+		{"trusted", "10.0.0.1:1234", map[string]string{"X-Tls-Session-Id": "abc"}, "abc"},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = v.remoteAddr
+		for k, h := range v.headers {
+			r.Header.Set(k, h)
+		}
+		env := tlsEnvParams(r)
+		if got := env["HTTPS_SESSIONID"]; got != v.want {
+			t.Errorf("%s: HTTPS_SESSIONID = \"%s\", want \"%s\"", v.name, got, v.want)
+		}
+		if _, ok := env["HTTPS_SERVER_SUBJECT"]; !ok {
+			t.Errorf("%s: HTTPS_SERVER_SUBJECT should be set", v.name)
+		}
+	}
+}
@@ -2,6 +2,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net"
 	"net/http"
 	//"net/url"
@@ -12,17 +14,10 @@ import (
 )
 
 func makeHandlerID(isSpecial bool, userName, userPass, debugIP string, req *http.Request) string {
-	addr := ""
+	host := ""
 	if isSpecial {
-		addr = req.Header.Get("X-Real-IP")
-		if addr == "" {
-			addr = req.Header.Get("X-Forwarded-For")
-			if addr == "" {
-				addr = req.RemoteAddr
-			}
-		}
+		host = clientIP(req)
 	}
-	host, _, _ := net.SplitHostPort(addr)
 	if debugIP == "uuid" {
 		host = uuid.New()
 	}
@@ -34,6 +29,21 @@ func makeHandlerID(isSpecial bool, userName, userPass, debugIP string, req *http
 	return strings.ToUpper(userName + "|" + userPass + "|" + host + "|" + debugIP)
 }
 
+// sessionDigest возвращает обозначение сессии для лога. Идентификатор сессии содержит пароль пользователя
+func sessionDigest(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// remoteIP возвращает адрес клиента без порта
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
 func makeTaskID(req *http.Request) string {
 	mID := req.FormValue("MessageId")
 	if mID == "" {
@@ -0,0 +1,163 @@
+// compress
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const defCompressMinSize = 1024
+
+type compressOptions struct {
+	// Level - степень сжатия 1..9, 0 - по умолчанию
+	Level int
+	// MinSize - ответы меньшего размера передаются без сжатия, 0 - defCompressMinSize
+	MinSize int
+}
+
+// compressEncodings - поддерживаемые способы сжатия в порядке предпочтения
+var compressEncodings = []string{"gzip", "deflate"}
+
+var compressors = map[string]func(w io.Writer, level int) (io.WriteCloser, error){
+	"gzip": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	},
+	"deflate": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	},
+}
+
+// incompressibleTypes - типы содержимого, которые уже сжаты
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-compress",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// compressibleHandler - сжатие ответов поддерживается для обработчиков OWA и SOAP
+func compressibleHandler(handlerType string) bool {
+	switch handlerType {
+	case "owa_apex", "owa_classic", "owa_ekb", "SOAP":
+		return true
+	}
+	return false
+}
+
+func validCompressLevel(level int) bool {
+	return level >= 0 && level <= gzip.BestCompression
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, v := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressWriter накапливает ответ, чтобы после его формирования решить, нужно ли сжатие.
+// Обработчики OWA и SOAP и так держат ответ в памяти целиком
+type compressWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// finish передает накопленный ответ клиенту, при возможности сжимая его
+func (w *compressWriter) finish(r *http.Request, opt compressOptions) {
+	if w.status == 0 {
+		return
+	}
+	h := w.Header()
+	body := w.buf.Bytes()
+
+	encoding := ""
+	// Content-Encoding мог быть задан из PL/SQL, тогда ответ уже закодирован
+	if h.Get("Content-Encoding") == "" && len(body) >= opt.MinSize && r.Method != "HEAD" &&
+		w.status >= http.StatusOK && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		contentType := h.Get("Content-Type")
+		if contentType == "" {
+			contentType = http.DetectContentType(body)
+		}
+		if compressibleType(contentType) {
+			h.Add("Vary", "Accept-Encoding")
+			for _, v := range compressEncodings {
+				if acceptsEncoding(r, v) {
+					encoding = v
+					break
+				}
+			}
+		}
+	}
+	if encoding != "" {
+		var buf bytes.Buffer
+		zw, err := compressors[encoding](&buf, opt.Level)
+		if err == nil {
+			_, err = zw.Write(body)
+			if err1 := zw.Close(); err == nil {
+				err = err1
+			}
+		}
+		if err != nil {
+			logError("Error compressing response: ", err)
+		} else {
+			h.Set("Content-Encoding", encoding)
+			h.Del("Content-Length")
+			body = buf.Bytes()
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// newCompress сжимает ответы обработчика handle в соответствии с Accept-Encoding запроса
+func newCompress(handle httprouter.Handle, opt compressOptions) httprouter.Handle {
+	if opt.Level == 0 {
+		opt.Level = gzip.DefaultCompression
+	}
+	if opt.MinSize == 0 {
+		opt.MinSize = defCompressMinSize
+	}
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		cw := &compressWriter{ResponseWriter: w}
+		handle(cw, r, p)
+		cw.finish(r, opt)
+	}
+}
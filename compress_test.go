@@ -0,0 +1,112 @@
+// compress_test
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>report line</p>\n", 200)
+
+	var tests = []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+		method      string
+		wantEnc     string
+	}{
+		{"gzip", "text/html", "gzip, deflate", page, "GET", "gzip"},
+		{"deflate", "text/html", "deflate", page, "GET", "deflate"},
+		{"q=0", "text/html", "gzip;q=0, deflate", page, "GET", "deflate"},
+		{"not accepted", "text/html", "", page, "GET", ""},
+		{"small", "text/html", "gzip", "<p>small</p>", "GET", ""},
+		{"image", "image/png", "gzip", page, "GET", ""},
+		{"svg", "image/svg+xml", "gzip", page, "GET", "gzip"},
+		{"detected", "", "gzip", page, "GET", "gzip"},
+		{"head", "text/html", "gzip", page, "HEAD", ""},
+		{"plsql", "text/html", "gzip", page, "GET", "identity"},
+	}
+	for _, v := range tests {
+		handle := newCompress(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			if v.contentType != "" {
+				w.Header().Set("Content-Type", v.contentType)
+			}
+			if v.wantEnc == "identity" {
+				// Заголовок, заданный из PL/SQL
+				w.Header().Set("Content-Encoding", "identity")
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(v.body))
+		}, compressOptions{Level: gzip.BestSpeed})
+
+		req := httptest.NewRequest(v.method, "/ti8/report", nil)
+		if v.encoding != "" {
+			req.Header.Set("Accept-Encoding", v.encoding)
+		}
+		rec := httptest.NewRecorder()
+		handle(rec, req, nil)
+
+		if rec.Code != http.StatusCreated {
+			t.Errorf("%s: got status %d, want %d", v.name, rec.Code, http.StatusCreated)
+		}
+		enc := rec.Header().Get("Content-Encoding")
+		if enc != v.wantEnc {
+			t.Errorf("%s: got Content-Encoding \"%s\", want \"%s\"", v.name, enc, v.wantEnc)
+			continue
+		}
+		var rd io.Reader = rec.Body
+		switch enc {
+		case "gzip":
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Errorf("%s: %s", v.name, err)
+				continue
+			}
+			rd = zr
+		case "deflate":
+			rd = flate.NewReader(rec.Body)
+		}
+		if enc == "gzip" || enc == "deflate" {
+			if rec.Body.Len() >= len(v.body) {
+				t.Errorf("%s: compressed size %d is not less than %d", v.name, rec.Body.Len(), len(v.body))
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("%s: got Vary \"%s\"", v.name, rec.Header().Get("Vary"))
+			}
+		}
+		buf, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Errorf("%s: %s", v.name, err)
+			continue
+		}
+		if string(buf) != v.body {
+			t.Errorf("%s: body differs after decompression", v.name)
+		}
+	}
+}
+
+func TestCompressConfig(t *testing.T) {
+	defer resetConfig()
+	for _, v := range []struct {
+		conf string
+		want string
+	}{
+		{`{"Http.Port":9979,"Http.Handlers":[{"Path":"/s","Type":"SOAP","Compression":true,"CompressionLevel":12}]}`, "out of range"},
+		{`{"Http.Port":9979,"Http.Handlers":[{"Path":"/s","Type":"SOAP","Compression":true,"CompressionMinSize":-1}]}`, "negative compression min size"},
+	} {
+		err := parseConfig([]byte(v.conf))
+		if err == nil || !strings.Contains(err.Error(), v.want) {
+			t.Errorf("%s: got error %v, want \"%s\"", v.conf, err, v.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// confhistory
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+type configVersion struct {
+	Version int
+	Applied time.Time
+	Hash    string
+	conf    []byte
+}
+
+var (
+	histLock        sync.Mutex
+	confHistorySize = 10
+	confHistory     []*configVersion
+	lastVersion     int
+	currentVersion  int
+	// Хеш последней конфигурации, прочитанной из источника (БД или файла)
+	lastSourceHash string
+	// Хеш конфигурации источника на момент отката. Пока источник не изменится, применяется закрепленная версия
+	pinnedSourceHash string
+)
+
+func configHash(buf []byte) string {
+	h := sha256.Sum256(buf)
+	return hex.EncodeToString(h[:])
+}
+
+// applyConfig применяет конфигурацию, прочитанную из источника, и сохраняет ее в истории версий.
+// Если ранее был выполнен откат, конфигурация не применяется до ее изменения в источнике
+func applyConfig(buf []byte) error {
+	histLock.Lock()
+	defer histLock.Unlock()
+
+	hash := configHash(buf)
+	lastSourceHash = hash
+	if pinnedSourceHash != "" {
+		if pinnedSourceHash == hash {
+			return nil
+		}
+		readerLog.Printf("Service %s - Configuration was changed, version %d is unpinned\n", confServiceName, currentVersion)
+		pinnedSourceHash = ""
+	}
+	if err := parseConfig(buf); err != nil {
+		return err
+	}
+	addConfigVersion(buf, hash)
+	return nil
+}
+
+// addConfigVersion вызывается под histLock
+func addConfigVersion(buf []byte, hash string) {
+	if len(confHistory) != 0 && confHistory[len(confHistory)-1].Hash == hash {
+		currentVersion = confHistory[len(confHistory)-1].Version
+		return
+	}
+	lastVersion++
+	confHistory = append(confHistory, &configVersion{
+		Version: lastVersion,
+		Applied: time.Now(),
+		Hash:    hash,
+		conf:    append([]byte(nil), buf...),
+	})
+	if confHistorySize > 0 && len(confHistory) > confHistorySize {
+		confHistory = append(confHistory[:0], confHistory[len(confHistory)-confHistorySize:]...)
+	}
+	currentVersion = lastVersion
+}
+
+// findConfigVersion вызывается под histLock
+func findConfigVersion(version int) *configVersion {
+	for _, v := range confHistory {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// rollbackConfig применяет сохраненную версию и закрепляет ее до изменения конфигурации в источнике
+func rollbackConfig(version int) error {
+	histLock.Lock()
+	defer histLock.Unlock()
+
+	v := findConfigVersion(version)
+	if v == nil {
+		return errgo.Newf("Configuration version %d does not exists", version)
+	}
+	if err := parseConfig(v.conf); err != nil {
+		return err
+	}
+	currentVersion = v.Version
+	if v.Hash == lastSourceHash {
+		pinnedSourceHash = ""
+	} else {
+		pinnedSourceHash = lastSourceHash
+	}
+	readerLog.Printf("Service %s - Configuration was rolled back to version %d\n", confServiceName, version)
+	return nil
+}
+
+type configDiff struct {
+	Version int
+	Current int
+	Server  []string
+	Added   []string
+	Removed []string
+	Changed map[string][]string
+}
+
+// diffHandlerKey возвращает обозначение обработчика при сравнении конфигураций: виртуальный хост и путь
+// с учетом PathCase. Один путь может быть зарегистрирован для нескольких хостов
+func diffHandlerKey(h *handlerConfigHolder) string {
+	return normalizeHost(h.Host) + casePath(h.Path, h.PathCase)
+}
+
+// diffConfigs сравнивает две конфигурации. Возвращаются только имена изменившихся параметров, но не их значения
+func diffConfigs(from, to []byte) (configDiff, error) {
+	var (
+		d      = configDiff{Changed: make(map[string][]string)}
+		cf, ct serverConfigHolder
+	)
+	if err := json.Unmarshal(from, &cf); err != nil {
+		return d, errgo.Newf("error parsing configuration: %s", err)
+	}
+	if err := json.Unmarshal(to, &ct); err != nil {
+		return d, errgo.Newf("error parsing configuration: %s", err)
+	}
+	handlersFrom, handlersTo := cf.Handlers, ct.Handlers
+	cf.Handlers, ct.Handlers = nil, nil
+	d.Server = changedFields(cf, ct)
+
+	hf := make(map[string]handlerConfigHolder, len(handlersFrom))
+	for _, h := range handlersFrom {
+		hf[diffHandlerKey(&h)] = h
+	}
+	ht := make(map[string]handlerConfigHolder, len(handlersTo))
+	for _, h := range handlersTo {
+		ht[diffHandlerKey(&h)] = h
+	}
+	for p, h := range ht {
+		old, ok := hf[p]
+		if !ok {
+			d.Added = append(d.Added, p)
+			continue
+		}
+		if fields := changedFields(old, h); len(fields) != 0 {
+			d.Changed[p] = fields
+		}
+	}
+	for p := range hf {
+		if _, ok := ht[p]; !ok {
+			d.Removed = append(d.Removed, p)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	return d, nil
+}
+
+// changedFields возвращает JSON имена полей, значения которых отличаются
+func changedFields(a, b interface{}) []string {
+	var ma, mb map[string]interface{}
+	bufA, _ := json.Marshal(a)
+	bufB, _ := json.Marshal(b)
+	json.Unmarshal(bufA, &ma)
+	json.Unmarshal(bufB, &mb)
+
+	var res []string
+	for k, va := range ma {
+		if !reflect.DeepEqual(va, mb[k]) {
+			res = append(res, k)
+		}
+	}
+	for k := range mb {
+		if _, ok := ma[k]; !ok {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func confHistoryList(w http.ResponseWriter, r *http.Request) {
+	type versionInfo struct {
+		configVersion
+		Size    int
+		Current bool
+		Pinned  bool
+	}
+	histLock.Lock()
+	res := make([]versionInfo, 0, len(confHistory))
+	for i := len(confHistory) - 1; i >= 0; i-- {
+		v := confHistory[i]
+		res = append(res, versionInfo{*v, len(v.conf), v.Version == currentVersion, v.Version == currentVersion && pinnedSourceHash != ""})
+	}
+	histLock.Unlock()
+
+	buf, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+func confHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Parameter \"version\" is required"))
+		return
+	}
+	histLock.Lock()
+	v, cur := findConfigVersion(version), findConfigVersion(currentVersion)
+	histLock.Unlock()
+	if v == nil || cur == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Configuration version does not exists"))
+		return
+	}
+
+	d, err := diffConfigs(v.conf, cur.conf)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	d.Version, d.Current = v.Version, cur.Version
+	buf, err := json.Marshal(d)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+func confHistoryRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Parameter \"version\" is required"))
+		return
+	}
+	if err := rollbackConfig(version); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
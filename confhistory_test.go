@@ -0,0 +1,88 @@
+// confhistory_test
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestConfigHistory(t *testing.T) {
+	readerLog = log.New(ioutil.Discard, "", 0)
+	resetConfig()
+
+	var (
+		conf1 = []byte(`{"Http.Port":9977,"Http.Handlers":[{"Path":"/a","Type":"Redirect","RedirectPath":"/b"}]}`)
+		conf2 = []byte(`{"Http.Port":9977,"Http.Handlers":[{"Path":"/a","Type":"Redirect","RedirectPath":"/c"},{"Path":"/s","Type":"Static","RootDir":"."}]}`)
+		conf3 = []byte(`{"Http.Port":9978,"Http.Handlers":[]}`)
+	)
+	confHistorySize = 2
+
+	for _, buf := range [][]byte{conf1, conf1, conf2} {
+		if err := applyConfig(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(confHistory) != 2 || currentVersion != 2 {
+		t.Fatalf("got %d versions, current %d, want 2 and 2", len(confHistory), currentVersion)
+	}
+
+	d, err := diffConfigs(conf1, conf2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.Added, []string{"/s"}) || len(d.Removed) != 0 ||
+		!reflect.DeepEqual(d.Changed, map[string][]string{"/a": {"RedirectPath"}}) || len(d.Server) != 0 {
+		t.Errorf("diff: got %+v", d)
+	}
+
+	if err := rollbackConfig(1); err != nil {
+		t.Fatal(err)
+	}
+	if currentVersion != 1 || pinnedSourceHash == "" {
+		t.Fatalf("after rollback: current %d, pinned \"%s\"", currentVersion, pinnedSourceHash)
+	}
+	// Пока конфигурация в источнике не изменилась, действует закрепленная версия
+	if err := applyConfig(conf2); err != nil {
+		t.Fatal(err)
+	}
+	if currentVersion != 1 || confHTTPPort != 9977 {
+		t.Fatalf("pinned: current %d", currentVersion)
+	}
+	if err := applyConfig(conf3); err != nil {
+		t.Fatal(err)
+	}
+	if currentVersion != 3 || pinnedSourceHash != "" || confHTTPPort != 9978 {
+		t.Fatalf("unpinned: current %d, pinned \"%s\", port %d", currentVersion, pinnedSourceHash, confHTTPPort)
+	}
+	if len(confHistory) != 2 || confHistory[0].Version != 2 {
+		t.Fatalf("history should keep versions 2 and 3, got %d versions", len(confHistory))
+	}
+	if err := rollbackConfig(1); err == nil {
+		t.Fatal("Should be error")
+	}
+}
+
+func TestDiffConfigsHosts(t *testing.T) {
+	var (
+		from = []byte(`{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/a","Host":"one.example.com","Type":"Redirect","RedirectPath":"/b"},
+			{"Path":"/a","Host":"two.example.com","Type":"Redirect","RedirectPath":"/b"},
+			{"Path":"/p","Type":"Redirect","PathCase":"preserve","RedirectPath":"/b"},
+			{"Path":"/P","Type":"Redirect","PathCase":"preserve","RedirectPath":"/b"}]}`)
+		to = []byte(`{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/a","Host":"One.Example.com","Type":"Redirect","RedirectPath":"/c"},
+			{"Path":"/a","Host":"three.example.com","Type":"Redirect","RedirectPath":"/b"},
+			{"Path":"/p","Type":"Redirect","PathCase":"preserve","RedirectPath":"/b"},
+			{"Path":"/P","Type":"Redirect","PathCase":"preserve","RedirectPath":"/c"}]}`)
+	)
+	d, err := diffConfigs(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.Added, []string{"three.example.com/a"}) || !reflect.DeepEqual(d.Removed, []string{"two.example.com/a"}) ||
+		!reflect.DeepEqual(d.Changed, map[string][]string{"one.example.com/a": {"Host", "RedirectPath"}, "/P": {"RedirectPath"}}) {
+		t.Errorf("diff: got %+v", d)
+	}
+}
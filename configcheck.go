@@ -0,0 +1,406 @@
+// configcheck
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vsdutka/iplsgo/otasker"
+)
+
+const (
+	issueError   = "ERROR"
+	issueWarning = "WARNING"
+)
+
+// owaTemplateCodes - шаблоны, которые используются обработчиками owa_*
+var owaTemplateCodes = []string{"error", "rwait", "rbreak", "rbreakr", "rwi", "InsufficientPrivileges", "AccountIsLocked"}
+
+type configIssue struct {
+	Severity string
+	Handler  string
+	Field    string
+	Message  string
+}
+
+type configReport struct {
+	Errors   int
+	Warnings int
+	Issues   []configIssue
+}
+
+func (r *configReport) add(severity, handler, field, format string, a ...interface{}) {
+	switch severity {
+	case issueError:
+		r.Errors++
+	case issueWarning:
+		r.Warnings++
+	}
+	r.Issues = append(r.Issues, configIssue{severity, handler, field, fmt.Sprintf(format, a...)})
+}
+
+func (r *configReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Configuration check: %d error(s), %d warning(s)\n", r.Errors, r.Warnings)
+	if len(r.Issues) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, v := range r.Issues {
+		handler := v.Handler
+		if handler == "" {
+			handler = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Severity, handler, v.Field, v.Message)
+	}
+	tw.Flush()
+}
+
+// checkConfig проверяет конфигурацию, не применяя ее
+func checkConfig(buf []byte) configReport {
+	var r configReport
+
+	var c = serverConfigHolder{
+		HTTPReadTimeout:  defHTTPtimeout,
+		HTTPWriteTimeout: defHTTPtimeout,
+	}
+	if err := json.Unmarshal(buf, &c); err != nil {
+		r.add(issueError, "", "", "error parsing configuration: %s", err)
+		return r
+	}
+	if err := resolveConfigSecrets(&c, make(map[string]bool)); err != nil {
+		r.add(issueError, "", "", "%s", err)
+	}
+
+	if c.HTTPPort == 0 {
+		r.add(issueError, "", "Http.Port", "port is not set")
+	}
+	if c.ShutdownTimeout < 0 {
+		r.add(issueError, "", "Http.ShutdownTimeout", "negative timeout %d", c.ShutdownTimeout)
+	}
+	if c.ShutdownDrain < 0 {
+		r.add(issueError, "", "Http.ShutdownDrainDelay", "negative delay %d", c.ShutdownDrain)
+	}
+	if c.HTTPSsl {
+		if _, err := loadCertificates(serverCertSources(c.HTTPSslCert, c.HTTPSslKey, c.HTTPSslCertFile, c.HTTPSslKeyFile, c.HTTPCertificates)); err != nil {
+			r.add(issueError, "", "Http.SSLCert", "invalid certificate or key: %s", err)
+		}
+		if _, _, err := parseTLSOptions(c.HTTPSslMinVer, c.HTTPSslCiphers); err != nil {
+			r.add(issueError, "", "Http.SSLMinVersion", "%s", err)
+		}
+		if _, _, err := parseClientAuth(c.HTTPClientAuth, c.HTTPClientCA); err != nil {
+			r.add(issueError, "", "Http.SSLClientAuth", "%s", err)
+		}
+	}
+
+	if !validLogFormat(c.HTTPLogFormat) {
+		r.add(issueError, "", "Http.LogFormat", "unknown log format \"%s\"", c.HTTPLogFormat)
+	}
+	if !validLogRotation(c.HTTPLogRotation) {
+		r.add(issueError, "", "Http.LogRotation", "unknown log rotation \"%s\"", c.HTTPLogRotation)
+	}
+	for _, v := range []struct {
+		field string
+		value int
+	}{
+		{"Http.LogMaxSize", c.HTTPLogMaxSize},
+		{"Http.LogMaxFiles", c.HTTPLogMaxFiles},
+		{"Http.LogMaxAge", c.HTTPLogMaxAge},
+	} {
+		if v.value < 0 {
+			r.add(issueError, "", v.field, "negative value %d", v.value)
+		}
+	}
+
+	if err := otasker.CheckRedactPatterns(c.HTTPRedactParams); err != nil {
+		r.add(issueError, "", "Http.RedactParams", "%s", err)
+	}
+
+	if _, err := parseIPList(c.HTTPTrustedProxy); err != nil {
+		r.add(issueError, "", "Http.TrustedProxies", "%s", err)
+	}
+	if _, err := newIPFilter(c.HTTPDebugAllow, c.HTTPDebugDeny); err != nil {
+		r.add(issueError, "", "Http.DebugAllowIPs", "%s", err)
+	}
+	if _, err := parseIPList(c.HTTPProxyFrom); err != nil {
+		r.add(issueError, "", "Http.ProxyProtocolFrom", "%s", err)
+	}
+	if (c.HTTPProxyProto || c.HTTPDebugProxy) && len(c.HTTPProxyFrom) == 0 {
+		r.add(issueWarning, "", "Http.ProxyProtocolFrom", "PROXY protocol is enabled, but no trusted sources are set")
+	}
+
+	var users []userConfigHolder
+	if len(c.HTTPUsers) != 0 {
+		if err := json.Unmarshal(c.HTTPUsers, &users); err != nil {
+			r.add(issueError, "", "Http.Users", "error parsing users: %s", err)
+		}
+	}
+	userGrps := make(map[string]int32, len(users))
+	for _, u := range users {
+		name := strings.ToUpper(u.Name)
+		if _, ok := userGrps[name]; ok {
+			r.add(issueWarning, "", "Http.Users", "user \"%s\" is defined more than once", u.Name)
+		}
+		userGrps[name] = u.GRP_ID
+	}
+
+	allGrps := make(map[int32]bool)
+	rt := newVhostRouter()
+	noop := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {}
+
+	for k := range c.Handlers {
+		h := &c.Handlers[k]
+		if h.Path == "" {
+			r.add(issueWarning, fmt.Sprintf("#%d", k), "Path", "path is empty, handler is skipped")
+			continue
+		}
+		upath := casePath(h.Path, h.PathCase)
+		if !validPathCase(h.PathCase) {
+			r.add(issueError, h.Path, "PathCase", "unknown path case policy \"%s\"", h.PathCase)
+		}
+		if host := normalizeHost(h.Host); strings.ContainsAny(host, ":/") || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			r.add(issueError, h.Path, "Host", "invalid host \"%s\", host name or \"*.domain\" pattern is expected", h.Host)
+		}
+
+		routePath, methods := handlerRoute(h.Type, upath)
+		if methods == nil {
+			r.add(issueError, h.Path, "Type", "unknown handler type \"%s\"", h.Type)
+			continue
+		}
+		if len(h.ResponseHeaders) != 0 {
+			if h.Type == "Proxy" {
+				r.add(issueWarning, h.Path, "ResponseHeaders", "ignored for Proxy handler, use proxy.ResponseHeaders")
+			}
+			checkHeaderRules(&r, h.Path, "ResponseHeaders", h.ResponseHeaders)
+		}
+		if h.CORS != nil {
+			if err := checkCORSOptions(h.CORS); err != nil {
+				r.add(issueError, h.Path, "CORS", "%s", err)
+			}
+			if !containsFold(methods, "OPTIONS") {
+				methods = append(methods, "OPTIONS")
+			}
+		}
+		for _, method := range methods {
+			if err := addRoute(rt.routerFor(h.Host, h.PathCase), method, routePath, noop); err != nil {
+				r.add(issueError, h.Path, "Path", "%s %s: %s", method, routePath, err)
+				break
+			}
+		}
+
+		if h.Compression && !compressibleHandler(h.Type) {
+			r.add(issueWarning, h.Path, "Compression", "compression is supported only for OWA and SOAP handlers")
+		}
+		if !validCompressLevel(h.CompressionLevel) {
+			r.add(issueError, h.Path, "CompressionLevel", "compression level %d is out of range 0..9", h.CompressionLevel)
+		}
+		if h.CompressionMinSize < 0 {
+			r.add(issueError, h.Path, "CompressionMinSize", "negative size %d", h.CompressionMinSize)
+		}
+		if h.RateLimit < 0 {
+			r.add(issueError, h.Path, "RateLimit", "negative rate %v", h.RateLimit)
+		}
+		if h.RateBurst < 0 {
+			r.add(issueError, h.Path, "RateBurst", "negative burst %d", h.RateBurst)
+		}
+		if !validRateKey(h.RateLimitKey) {
+			r.add(issueError, h.Path, "RateLimitKey", "unknown rate limit key \"%s\"", h.RateLimitKey)
+		}
+		if _, err := newIPFilter(h.AllowIPs, h.DenyIPs); err != nil {
+			r.add(issueError, h.Path, "AllowIPs", "%s", err)
+		}
+		if _, err := newIPFilter(h.AdminAllowIPs, h.AdminDenyIPs); err != nil {
+			r.add(issueError, h.Path, "owa.AdminAllowIPs", "%s", err)
+		}
+
+		switch h.Type {
+		case "Redirect":
+			if h.RedirectPath == "" {
+				r.add(issueError, h.Path, "RedirectPath", "redirect path is not set")
+			}
+		case "Static":
+			if h.RootDir == "" {
+				r.add(issueError, h.Path, "RootDir", "root directory is not set")
+			} else if fi, err := os.Stat(h.RootDir); err != nil {
+				r.add(issueError, h.Path, "RootDir", "%s", err)
+			} else if !fi.IsDir() {
+				r.add(issueError, h.Path, "RootDir", "\"%s\" is not a directory", h.RootDir)
+			}
+			if strings.ContainsAny(h.StaticIndexFile, "/\\") {
+				r.add(issueError, h.Path, "static.IndexFile", "index file \"%s\" should be a file name", h.StaticIndexFile)
+			}
+			for _, v := range h.StaticCacheRules {
+				if len(v.Ext) == 0 {
+					r.add(issueWarning, h.Path, "static.CacheRules", "cache rule has no extensions")
+				}
+				if v.MaxAge < 0 {
+					r.add(issueError, h.Path, "static.CacheRules", "negative MaxAge %d", v.MaxAge)
+				}
+			}
+		case "owa_apex", "owa_classic", "owa_ekb":
+			checkOwaHandler(&r, h, userGrps)
+			if h.ClientCertUser != "" && (!c.HTTPSsl || c.HTTPClientAuth == "" || strings.EqualFold(c.HTTPClientAuth, clientAuthNone)) {
+				r.add(issueWarning, h.Path, "owa.ClientCertUser", "client certificates are not requested, set Http.SSL and Http.SSLClientAuth")
+			}
+			for _, v := range h.Grps {
+				allGrps[v.ID] = true
+			}
+		case "SOAP":
+			if h.SoapUserName == "" {
+				r.add(issueError, h.Path, "soap.DBUserName", "user name is not set")
+			}
+			if h.SoapConnStr == "" {
+				r.add(issueError, h.Path, "soap.DBConnStr", "connection string is not set")
+			}
+		case "Proxy":
+			if _, err := parseUpstreams(h.ProxyUpstreams); err != nil {
+				r.add(issueError, h.Path, "proxy.Upstreams", "%s", err)
+			}
+			checkHeaderRules(&r, h.Path, "proxy.RequestHeaders", h.ProxyRequestHeaders)
+			checkHeaderRules(&r, h.Path, "proxy.ResponseHeaders", h.ProxyResponseHeaders)
+		}
+	}
+
+	for _, u := range users {
+		if !allGrps[u.GRP_ID] {
+			r.add(issueWarning, "", "Http.Users", "user \"%s\" references group %d which is not defined in any handler", u.Name, u.GRP_ID)
+		}
+	}
+	return r
+}
+
+func checkHeaderRules(r *configReport, path, field string, rules []headerRule) {
+	for _, v := range rules {
+		if v.Name == "" {
+			r.add(issueError, path, field, "header name is not set")
+		}
+		if !validHeaderAction(v.Action) {
+			r.add(issueError, path, field, "unknown action \"%s\" for header \"%s\"", v.Action, v.Name)
+		}
+	}
+}
+
+func checkOwaHandler(r *configReport, h *handlerConfigHolder, userGrps map[string]int32) {
+	templates := make(map[string]bool, len(h.Templates))
+	for _, v := range h.Templates {
+		if templates[v.Code] {
+			r.add(issueWarning, h.Path, "owa.Templates", "template \"%s\" is defined more than once", v.Code)
+		}
+		templates[v.Code] = true
+		if _, err := template.New(v.Code).Parse(v.Body); err != nil {
+			r.add(issueError, h.Path, "owa.Templates", "template \"%s\": %s", v.Code, err)
+		}
+	}
+	for _, code := range owaTemplateCodes {
+		if !templates[code] {
+			r.add(issueWarning, h.Path, "owa.Templates", "template \"%s\" is not defined", code)
+		}
+	}
+
+	grps := make(map[int32]bool, len(h.Grps))
+	for _, v := range h.Grps {
+		grps[v.ID] = true
+		if v.SID == "" {
+			r.add(issueWarning, h.Path, "owa.UserGroups", "group %d has empty connection string", v.ID)
+		}
+	}
+	if len(grps) == 0 {
+		r.add(issueError, h.Path, "owa.UserGroups", "no user groups defined, nobody can log in")
+	}
+
+	if h.MaxUserSessions < 0 {
+		r.add(issueError, h.Path, "owa.MaxUserSessions", "negative number of sessions %d", h.MaxUserSessions)
+	}
+	if h.ClientCertUser != "" {
+		if !validCertUserField(h.ClientCertUser) {
+			r.add(issueError, h.Path, "owa.ClientCertUser", "unknown certificate field \"%s\"", h.ClientCertUser)
+		}
+		if h.DefUserName == "" {
+			r.add(issueError, h.Path, "owa.DBUserName", "proxy user name is required when owa.ClientCertUser is set")
+		}
+	}
+
+	if !h.RequestUserInfo {
+		if h.DefUserName == "" {
+			r.add(issueError, h.Path, "owa.DBUserName", "user name is required when owa.ReqUserInfo is false")
+			return
+		}
+		grpID, ok := userGrps[strings.ToUpper(h.DefUserName)]
+		if !ok {
+			r.add(issueError, h.Path, "owa.DBUserName", "user \"%s\" is not defined in Http.Users", h.DefUserName)
+		} else if !grps[grpID] {
+			r.add(issueError, h.Path, "owa.DBUserName", "group %d of user \"%s\" is not defined in owa.UserGroups", grpID, h.DefUserName)
+		}
+	}
+}
+
+// checkConfigCommand читает конфигурацию из файла или БД, проверяет ее и печатает отчет.
+// Возвращает код завершения процесса
+func checkConfigCommand() int {
+	var (
+		buf []byte
+		err error
+	)
+	applyFlags()
+	if *confFileFlag != "" {
+		if err = initFileReading(*confFileFlag); err == nil {
+			buf, err = readConfigFile()
+		}
+	} else {
+		if (*confNameFlag == "") || (*dsnFlag == "") {
+			usage()
+			return 2
+		}
+		if err = initReading(*dsnFlag, *confNameFlag); err == nil {
+			buf, err = readConfig()
+		}
+		if conn != nil {
+			conn.Close()
+			conn.Free(true)
+			conn = nil
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error read configuration: %s\n", err)
+		return 2
+	}
+
+	r := checkConfig(buf)
+	r.print(os.Stdout)
+	if r.Errors != 0 {
+		return 1
+	}
+	return 0
+}
+
+// confCheck проверяет текущую конфигурацию (GET) или переданную в теле запроса (POST)
+func confCheck(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	if r.Method == "POST" {
+		var err error
+		if buf, err = ioutil.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	} else {
+		confLock.RLock()
+		buf = append(buf, prevConf...)
+		confLock.RUnlock()
+	}
+	buf, err := json.Marshal(checkConfig(buf))
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
@@ -0,0 +1,117 @@
+// configcheck_test
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const owaTemplates = `"owa.Templates":[{"Code":"error","Body":"{{.ErrMsg}}"},{"Code":"rwait","Body":""},{"Code":"rbreak","Body":""},{"Code":"rbreakr","Body":""},{"Code":"rwi","Body":""},{"Code":"InsufficientPrivileges","Body":""},{"Code":"AccountIsLocked","Body":""}]`
+
+	var tests = []struct {
+		name     string
+		conf     string
+		errors   int
+		warnings int
+		contains string
+	}{
+		{"valid", `{"Http.Port":80,"Http.Users":[{"Name":"U1","GRP_ID":1}],"Http.Handlers":[
+			{"Path":"/","Type":"Redirect","RedirectPath":"/i"},
+			{"Path":"/i","Type":"Static","RootDir":"` + dir + `"},
+			{"Path":"/ti8","Type":"owa_classic","owa.ReqUserInfo":false,"owa.DBUserName":"u1",` + owaTemplates + `,"owa.UserGroups":[{"ID":1,"SID":"db"}]}]}`,
+			0, 0, ""},
+		{"syntax", `{"Http.Port":`, 1, 0, "error parsing configuration"},
+		{"no port", `{}`, 1, 0, "port is not set"},
+		{"shutdown", `{"Http.Port":80,"Http.ShutdownTimeout":-1,"Http.ShutdownDrainDelay":-1}`, 2, 0, "Http.ShutdownDrainDelay negative delay"},
+		{"empty path", `{"Http.Port":80,"Http.Handlers":[{"Type":"Redirect"}]}`, 0, 1, "path is empty"},
+		{"unknown type", `{"Http.Port":80,"Http.Handlers":[{"Path":"/a","Type":"Proxyy"}]}`, 1, 0, "unknown handler type"},
+		{"redirect", `{"Http.Port":80,"Http.Handlers":[{"Path":"/a","Type":"Redirect"}]}`, 1, 0, "redirect path is not set"},
+		{"root dir", `{"Http.Port":80,"Http.Handlers":[{"Path":"/a","Type":"Static","RootDir":"` + dir + `/absent"}]}`, 1, 0, "RootDir"},
+		{"duplicate", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/S","Type":"SOAP","soap.DBUserName":"u","soap.DBConnStr":"db"}]}`, 1, 0, "/s/*proc"},
+		{"conflict", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/s/a","Type":"SOAP","soap.DBUserName":"u","soap.DBConnStr":"db"}]}`, 1, 0, "/s/a/*proc"},
+		{"hosts", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/s","Type":"SOAP","Host":"a.example.com","soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/s","Type":"SOAP","Host":"*.example.com:80","soap.DBUserName":"u","soap.DBConnStr":"db"}]}`, 1, 0, "invalid host"},
+		{"path case", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/S","Type":"SOAP","PathCase":"preserve","soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/S","Type":"SOAP","soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/a","Type":"SOAP","PathCase":"upper","soap.DBUserName":"u","soap.DBConnStr":"db"}]}`, 1, 0, "unknown path case policy"},
+		{"template", `{"Http.Port":80,"Http.Users":[{"Name":"U1","GRP_ID":1}],"Http.Handlers":[
+			{"Path":"/ti8","Type":"owa_classic","owa.ReqUserInfo":true,"owa.Templates":[{"Code":"error","Body":"{{.ErrMsg"}],"owa.UserGroups":[{"ID":1,"SID":"db"}]}]}`,
+			1, 6, "unclosed action"},
+		{"default user", `{"Http.Port":80,"Http.Users":[{"Name":"U1","GRP_ID":2}],"Http.Handlers":[
+			{"Path":"/ti8","Type":"owa_classic","owa.DBUserName":"u1",` + owaTemplates + `,"owa.UserGroups":[{"ID":1,"SID":"db"}]}]}`,
+			1, 1, "group 2 of user \"u1\""},
+		{"no groups", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/ti8","Type":"owa_apex","owa.ReqUserInfo":true,` + owaTemplates + `}]}`,
+			1, 0, "no user groups defined"},
+		{"client cert", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/ti8","Type":"owa_apex","owa.ReqUserInfo":true,"owa.ClientCertUser":"OU",` + owaTemplates + `,"owa.UserGroups":[{"ID":1,"SID":"db"}]}]}`,
+			2, 1, "unknown certificate field"},
+		{"static", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/i","Type":"Static","RootDir":"` + dir + `","static.IndexFile":"a/index.html","static.CacheRules":[{"MaxAge":-1}]}]}`,
+			2, 1, "should be a file name"},
+		{"proxy", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/p","Type":"Proxy","proxy.Upstreams":["backend:8080"],"proxy.RequestHeaders":[{"Action":"replace","Name":"X-A"}]}]}`,
+			2, 0, "absolute http(s) URL"},
+		{"compression", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","Compression":true,"CompressionLevel":12,"soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/a","Type":"Redirect","RedirectPath":"/s","Compression":true}]}`,
+			1, 1, "out of range"},
+		{"rate limit", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","RateLimit":-1,"RateLimitKey":"session","soap.DBUserName":"u","soap.DBConnStr":"db"}]}`,
+			2, 0, "unknown rate limit key"},
+		{"ip lists", `{"Http.Port":80,"Http.TrustedProxies":["10.0.0.0/33"],"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","AllowIPs":["10.0.0.0/8","::1"],"DenyIPs":["host"],"soap.DBUserName":"u","soap.DBConnStr":"db"}]}`,
+			2, 0, "invalid IP address \"host\""},
+		{"ssl", `{"Http.Port":80,"Http.SSL":true,"Http.SSLCertFile":"` + dir + `/absent.pem","Http.SSLKeyFile":"` + dir + `/absent.key","Http.SSLMinVersion":"SSL3"}`,
+			2, 0, "unknown TLS version"},
+		{"log", `{"Http.Port":80,"Http.LogFormat":"common","Http.LogRotation":"weekly","Http.LogMaxSize":100,"Http.LogMaxFiles":-1}`,
+			3, 0, "unknown log format \"common\""},
+		{"proxy protocol", `{"Http.Port":80,"Http.ProxyProtocol":true}`, 0, 1, "no trusted sources"},
+		{"cors", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"SOAP","CORS":{"Origins":["https://[a"]},"soap.DBUserName":"u","soap.DBConnStr":"db"},
+			{"Path":"/p","Type":"Proxy","proxy.Upstreams":["http://backend:8080"],"CORS":{"Origins":["*"],"MaxAge":-1}},
+			{"Path":"/r","Type":"Redirect","RedirectPath":"/s","CORS":{"Origins":["*"],"AllowCredentials":true}}]}`,
+			3, 0, "not allowed with AllowCredentials"},
+		{"response headers", `{"Http.Port":80,"Http.Handlers":[
+			{"Path":"/s","Type":"Static","RootDir":"/tmp","ResponseHeaders":[{"Name":"Strict-Transport-Security","Value":"max-age=31536000"},{"Action":"strip","Name":"Server"}]},
+			{"Path":"/p","Type":"Proxy","proxy.Upstreams":["http://backend:8080"],"ResponseHeaders":[{"Action":"remove","Name":"Server"}]}]}`,
+			1, 1, "unknown action \"strip\""},
+		{"redact", `{"Http.Port":80,"Http.RedactParams":["*pass*","p_t0[1"]}`, 1, 0, "p_t0[1"},
+	}
+
+	for _, v := range tests {
+		r := checkConfig([]byte(v.conf))
+		if r.Errors != v.errors || r.Warnings != v.warnings {
+			t.Errorf("%s: got %d error(s) and %d warning(s), want %d and %d: %+v", v.name, r.Errors, r.Warnings, v.errors, v.warnings, r.Issues)
+			continue
+		}
+		if v.contains == "" {
+			continue
+		}
+		found := false
+		for _, i := range r.Issues {
+			if strings.Contains(i.Field+" "+i.Message, v.contains) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: no issue contains \"%s\": %+v", v.name, v.contains, r.Issues)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// cors
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// corsDefMethods - методы, разрешенные, если Methods не задан
+var corsDefMethods = []string{"GET", "HEAD", "POST"}
+
+type corsOptions struct {
+	// Origins - разрешенные источники: "*", "https://app.example.com" или "https://*.example.com"
+	Origins []string
+	// Methods - разрешенные методы, по умолчанию corsDefMethods
+	Methods []string
+	// Headers - разрешенные заголовки запроса, "*" - любые
+	Headers []string
+	// ExposeHeaders - заголовки ответа, доступные скриптам
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// MaxAge - время кэширования ответа на preflight запрос в секундах
+	MaxAge int
+}
+
+func checkCORSOptions(opt *corsOptions) error {
+	if len(opt.Origins) == 0 {
+		return fmt.Errorf("allowed origins are not set")
+	}
+	for _, v := range opt.Origins {
+		if _, err := path.Match(strings.ToLower(v), ""); err != nil {
+			return fmt.Errorf("invalid origin pattern \"%s\"", v)
+		}
+		// Иначе любой сайт сможет выполнять запросы с учетными данными пользователя
+		if v == "*" && opt.AllowCredentials {
+			return fmt.Errorf("origin \"*\" is not allowed with AllowCredentials, list allowed origins explicitly")
+		}
+	}
+	if opt.MaxAge < 0 {
+		return fmt.Errorf("negative max age %d", opt.MaxAge)
+	}
+	return nil
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if s == "*" || strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt *corsOptions) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, v := range opt.Origins {
+		if v == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(v), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt *corsOptions) methods() []string {
+	if len(opt.Methods) == 0 {
+		return corsDefMethods
+	}
+	return opt.Methods
+}
+
+func (opt *corsOptions) setOrigin(h http.Header, origin string) {
+	if len(opt.Origins) == 1 && opt.Origins[0] == "*" {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if opt.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight отвечает на предварительный запрос OPTIONS
+func (opt *corsOptions) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !opt.originAllowed(origin) || !containsFold(opt.methods(), method) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var headers []string
+	for _, v := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !containsFold(opt.Headers, v) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		headers = append(headers, v)
+	}
+
+	opt.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(opt.methods(), ", "))
+	if len(headers) != 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if opt.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(opt.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newCORS обрабатывает запросы с других источников по настройкам opt. Предварительные запросы OPTIONS
+// обрабатываются без вызова handle. Остальные запросы OPTIONS передаются handle, только если он их
+// поддерживает (есть в methods)
+func newCORS(handle httprouter.Handle, opt *corsOptions, methods []string) httprouter.Handle {
+	handleOptions := containsFold(methods, "OPTIONS")
+	allow := strings.Join(methods, ", ")
+	if !handleOptions {
+		allow += ", OPTIONS"
+	}
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		origin := r.Header.Get("Origin")
+		if r.Method == "OPTIONS" {
+			if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+				opt.preflight(w, r, origin)
+				return
+			}
+			if !handleOptions {
+				w.Header().Set("Allow", allow)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		if origin == "" || !opt.originAllowed(origin) {
+			handle(w, r, p)
+			return
+		}
+		// Заголовки Access-Control-*, установленные обработчиком, заменяются заголовками из настроек
+		hw := &headerWriter{ResponseWriter: w, apply: func(h http.Header) {
+			for k := range h {
+				if strings.HasPrefix(k, "Access-Control-") {
+					h.Del(k)
+				}
+			}
+			opt.setOrigin(h, origin)
+			if len(opt.ExposeHeaders) != 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(opt.ExposeHeaders, ", "))
+			}
+		}}
+		hw.serve(handle, r, p)
+	}
+}
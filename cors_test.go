@@ -0,0 +1,152 @@
+// cors_test
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCORS(t *testing.T) {
+	calls := 0
+	backend := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		calls++
+		// Заголовок, установленный процедурой, заменяется настройками шлюза
+		w.Header().Add("Access-Control-Allow-Origin", "https://plsql.example.com")
+		w.Write([]byte("ok"))
+	}
+	opt := &corsOptions{
+		Origins:          []string{"https://app.example.com", "https://*.example.org"},
+		Headers:          []string{"Content-Type", "X-Token"},
+		ExposeHeaders:    []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+	handle := newCORS(backend, opt, []string{"GET", "POST"})
+
+	var tests = []struct {
+		name    string
+		method  string
+		headers map[string]string
+		code    int
+		called  bool
+		want    map[string]string
+	}{
+		{"no origin", "GET", nil, http.StatusOK, true, map[string]string{
+			"Access-Control-Allow-Origin": "https://plsql.example.com",
+		}},
+		{"allowed", "POST", map[string]string{"Origin": "https://app.example.com"}, http.StatusOK, true, map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    "X-Request-Id",
+			"Vary":                             "Origin",
+		}},
+		{"wildcard", "GET", map[string]string{"Origin": "https://a.example.org"}, http.StatusOK, true, map[string]string{
+			"Access-Control-Allow-Origin": "https://a.example.org",
+		}},
+		{"not allowed", "GET", map[string]string{"Origin": "https://evil.com"}, http.StatusOK, true, map[string]string{
+			"Access-Control-Allow-Origin": "https://plsql.example.com",
+		}},
+		{"preflight", "OPTIONS", map[string]string{
+			"Origin":                         "https://app.example.com",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type, x-token",
+		}, http.StatusNoContent, false, map[string]string{
+			"Access-Control-Allow-Origin":  "https://app.example.com",
+			"Access-Control-Allow-Methods": "GET, HEAD, POST",
+			"Access-Control-Allow-Headers": "content-type, x-token",
+			"Access-Control-Max-Age":       "600",
+		}},
+		{"preflight method", "OPTIONS", map[string]string{
+			"Origin":                        "https://app.example.com",
+			"Access-Control-Request-Method": "DELETE",
+		}, http.StatusForbidden, false, map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"preflight header", "OPTIONS", map[string]string{
+			"Origin":                         "https://app.example.com",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "X-Other",
+		}, http.StatusForbidden, false, nil},
+		{"preflight origin", "OPTIONS", map[string]string{
+			"Origin":                        "https://evil.com",
+			"Access-Control-Request-Method": "GET",
+		}, http.StatusForbidden, false, nil},
+		{"options", "OPTIONS", nil, http.StatusNoContent, false, map[string]string{"Allow": "GET, POST, OPTIONS"}},
+	}
+	for _, v := range tests {
+		calls = 0
+		r := httptest.NewRequest(v.method, "/ti8/p", nil)
+		for k, h := range v.headers {
+			r.Header.Set(k, h)
+		}
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		if w.Code != v.code || (calls != 0) != v.called {
+			t.Errorf("%s: got %d, called %d time(s)", v.name, w.Code, calls)
+		}
+		for k, want := range v.want {
+			if got := w.Header().Get(k); got != want {
+				t.Errorf("%s: %s: got \"%s\", want \"%s\"", v.name, k, got, want)
+			}
+		}
+		if n := len(w.Header()["Access-Control-Allow-Origin"]); n > 1 {
+			t.Errorf("%s: got %d Access-Control-Allow-Origin headers", v.name, n)
+		}
+	}
+
+	// Обработчик, поддерживающий OPTIONS, получает запросы OPTIONS, не являющиеся предварительными
+	handle = newCORS(backend, &corsOptions{Origins: []string{"*"}}, []string{"GET", "OPTIONS"})
+	calls = 0
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest("OPTIONS", "/p/a", nil), nil)
+	if calls != 1 {
+		t.Errorf("OPTIONS should be passed to handler")
+	}
+	r := httptest.NewRequest("GET", "/p/a", nil)
+	r.Header.Set("Origin", "https://any.com")
+	w = httptest.NewRecorder()
+	handle(w, r, nil)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Got Access-Control-Allow-Origin \"%s\", want \"*\"", got)
+	}
+}
+
+func TestCORSConfig(t *testing.T) {
+	if err := checkCORSOptions(&corsOptions{Origins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("Origin \"*\" with credentials should be rejected")
+	}
+	if err := checkCORSOptions(&corsOptions{Origins: []string{"https://*.example.com"}, AllowCredentials: true}); err != nil {
+		t.Errorf("Explicit origins with credentials: %s", err)
+	}
+
+	// Предварительный запрос с запрещенного адреса отклоняется фильтром
+	defer resetConfig()
+	if err := parseConfig([]byte(`{"Http.Port":9979,"Http.Handlers":[
+		{"Path":"/r","Type":"Redirect","RedirectPath":"/x","DenyIPs":["192.0.2.0/24"],"CORS":{"Origins":["https://app.example.com"]}}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	confLock.RLock()
+	rt := router
+	confLock.RUnlock()
+	for _, v := range []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"192.0.2.1:1234", http.StatusForbidden},
+		{"198.51.100.1:1234", http.StatusNoContent},
+	} {
+		r := httptest.NewRequest("OPTIONS", "/r", nil)
+		r.RemoteAddr = v.remoteAddr
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s: got status %d, want %d", v.remoteAddr, w.Code, v.code)
+		}
+		if v.code == http.StatusForbidden && w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: denied preflight should not allow origin", v.remoteAddr)
+		}
+	}
+}
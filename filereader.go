@@ -0,0 +1,80 @@
+// filereader
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var configFileName string
+
+func initFileReading(fileName string) error {
+	if fileName == "" {
+		return errgo.New("Configuration file name is empty")
+	}
+	var err error
+	if configFileName, err = filepath.Abs(fileName); err != nil {
+		return errgo.Newf("Error getting configuration file path: %s\n", err)
+	}
+	setReaderState(func(s *readerStatus) { s.Source = sourceFile })
+	initReaderLog()
+	return nil
+}
+
+func startFileReading(fileName string, timeout time.Duration) error {
+	if err := initFileReading(fileName); err != nil {
+		return err
+	}
+	return startPolling(readConfigFile, timeout)
+}
+
+// readConfigFile читает конфигурацию из файла и возвращает ее в виде JSON,
+// чтобы далее она обрабатывалась parseConfig так же, как прочитанная из БД
+func readConfigFile() ([]byte, error) {
+	buf, err := ioutil.ReadFile(configFileName)
+	if err != nil {
+		return nil, errgo.Newf("error reading `%s`: %s", configFileName, err)
+	}
+	switch strings.ToLower(filepath.Ext(configFileName)) {
+	case ".yaml", ".yml":
+		if buf, err = yamlToJSON(buf); err != nil {
+			return nil, errgo.Newf("error converting `%s` from YAML: %s", configFileName, err)
+		}
+	}
+	return buf, nil
+}
+
+func yamlToJSON(buf []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(convertYAMLValue(v))
+}
+
+// convertYAMLValue заменяет map[interface{}]interface{}, которые возвращает yaml, на map[string]interface{},
+// поддерживаемые encoding/json
+func convertYAMLValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = convertYAMLValue(val)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = convertYAMLValue(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
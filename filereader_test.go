@@ -0,0 +1,81 @@
+// filereader_test
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testConfJSON = `{"Service.Name":"iplsgo","Http.Port":9977,"Http.Users":[{"Name":"USER001","IsSpecial":false,"GRP_ID":1}],"Http.Handlers":[{"Path":"/images","Type":"Static","RootDir":"/wwwroot/images"}]}`
+	testConfYAML = `
+Service.Name: iplsgo
+Http.Port: 9977
+Http.Users:
+  - Name: USER001
+    IsSpecial: false
+    GRP_ID: 1
+Http.Handlers:
+  - Path: /images
+    Type: Static
+    RootDir: /wwwroot/images
+`
+)
+
+func TestReadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		fileName string
+		body     string
+	}{
+		{"conf.json", testConfJSON},
+		{"conf.yaml", testConfYAML},
+		{"conf.YML", testConfYAML},
+	}
+
+	var want serverConfigHolder
+	if err := json.Unmarshal([]byte(testConfJSON), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range tests {
+		fileName := filepath.Join(dir, v.fileName)
+		if err := ioutil.WriteFile(fileName, []byte(v.body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		configFileName = fileName
+		buf, err := readConfigFile()
+		if err != nil {
+			t.Fatalf("%s: %s", v.fileName, err)
+		}
+		var got serverConfigHolder
+		if err := json.Unmarshal(buf, &got); err != nil {
+			t.Fatalf("%s: %s", v.fileName, err)
+		}
+		if got.ServiceName != want.ServiceName || got.HTTPPort != want.HTTPPort ||
+			len(got.Handlers) != 1 || got.Handlers[0].RootDir != want.Handlers[0].RootDir {
+			t.Errorf("%s: got %+v, want %+v", v.fileName, got, want)
+		}
+		var users, wantUsers interface{}
+		json.Unmarshal(got.HTTPUsers, &users)
+		json.Unmarshal(want.HTTPUsers, &wantUsers)
+		gotBuf, _ := json.Marshal(users)
+		wantBuf, _ := json.Marshal(wantUsers)
+		if string(gotBuf) != string(wantBuf) {
+			t.Errorf("%s: users got %s, want %s", v.fileName, gotBuf, wantBuf)
+		}
+	}
+
+	configFileName = filepath.Join(dir, "absent.json")
+	if _, err := readConfigFile(); err == nil {
+		t.Error("Should be error")
+	}
+}
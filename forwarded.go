@@ -0,0 +1,168 @@
+// forwarded
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// forwardedRequest - параметры запроса со стороны клиента. Если запрос пришел от доверенного прокси (Http.TrustedProxies),
+// они берутся из заголовков Forwarded, X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host, X-Forwarded-Port и X-Real-IP
+type forwardedRequest struct {
+	// addr - адрес клиента без порта
+	addr  string
+	https bool
+	host  string
+	port  string
+}
+
+// forwardedElement - элемент заголовка Forwarded (RFC 7239)
+type forwardedElement struct {
+	addr  string
+	proto string
+	host  string
+}
+
+func splitHost(hostport string) (string, string) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.Trim(hostport, "[]"), ""
+	}
+	return host, port
+}
+
+// headerValues возвращает значения заголовка name, в том числе перечисленные через запятую
+func headerValues(r *http.Request, name string) []string {
+	var res []string
+	for _, v := range strings.Split(strings.Join(r.Header[name], ","), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func lastHeaderValue(r *http.Request, name string) string {
+	values := headerValues(r, name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+func parseForwarded(r *http.Request) []forwardedElement {
+	var res []forwardedElement
+	for _, v := range headerValues(r, "Forwarded") {
+		var e forwardedElement
+		for _, pair := range strings.Split(v, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value := strings.Trim(strings.TrimSpace(kv[1]), "\"")
+			switch strings.ToLower(kv[0]) {
+			case "for":
+				e.addr = value
+			case "proto":
+				e.proto = strings.ToLower(value)
+			case "host":
+				e.host = value
+			}
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// forwardedAddr возвращает адрес без порта. Для "unknown" и обфусцированных идентификаторов возвращается ""
+func forwardedAddr(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.Trim(addr, "[]")
+	if net.ParseIP(addr) == nil {
+		return ""
+	}
+	return addr
+}
+
+func trustedProxies() ipList {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return confTrustedProxies
+}
+
+// fromTrustedProxy возвращает true, если запрос пришел непосредственно от доверенного прокси
+func fromTrustedProxy(r *http.Request) bool {
+	return trustedProxies().contains(net.ParseIP(remoteIP(r)))
+}
+
+// forwarded возвращает параметры запроса со стороны клиента
+func forwarded(r *http.Request) forwardedRequest {
+	res := forwardedRequest{addr: remoteIP(r), https: r.TLS != nil}
+	res.host, res.port = splitHost(r.Host)
+
+	proxies := trustedProxies()
+	if !proxies.contains(net.ParseIP(res.addr)) {
+		return res
+	}
+
+	var proto, host string
+	if elements := parseForwarded(r); len(elements) != 0 {
+		// Элементы добавляются прокси по порядку, клиентом считается последний адрес, не принадлежащий доверенным прокси
+		for i := len(elements) - 1; i >= 0; i-- {
+			addr := forwardedAddr(elements[i].addr)
+			if addr == "" {
+				break
+			}
+			res.addr, proto, host = addr, elements[i].proto, elements[i].host
+			if !proxies.contains(net.ParseIP(addr)) {
+				break
+			}
+		}
+	} else {
+		if addrs := headerValues(r, "X-Forwarded-For"); len(addrs) != 0 {
+			for i := len(addrs) - 1; i >= 0; i-- {
+				addr := forwardedAddr(addrs[i])
+				if addr == "" {
+					break
+				}
+				res.addr = addr
+				if !proxies.contains(net.ParseIP(addr)) {
+					break
+				}
+			}
+		} else if addr := forwardedAddr(r.Header.Get("X-Real-IP")); addr != "" {
+			res.addr = addr
+		}
+		// Используются значения, добавленные ближайшим прокси
+		proto = strings.ToLower(lastHeaderValue(r, "X-Forwarded-Proto"))
+		host = lastHeaderValue(r, "X-Forwarded-Host")
+	}
+
+	switch proto {
+	case "https":
+		res.https = true
+	case "http":
+		res.https = false
+	}
+	if host != "" {
+		res.host, res.port = splitHost(host)
+	}
+	if port := lastHeaderValue(r, "X-Forwarded-Port"); port != "" && (host == "" || res.port == "") {
+		res.port = port
+	} else if res.port == "" && (proto != "" || host != "") {
+		res.port = "80"
+		if res.https {
+			res.port = "443"
+		}
+	}
+	return res
+}
+
+// clientIP возвращает адрес клиента. Если запрос пришел от доверенного прокси,
+// адрес берется из Forwarded или X-Forwarded-For: последний адрес, не принадлежащий доверенным прокси
+func clientIP(r *http.Request) string {
+	return forwarded(r).addr
+}
@@ -0,0 +1,94 @@
+// forwarded_test
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwarded(t *testing.T) {
+	proxies, _ := parseIPList([]string{"10.0.0.0/8"})
+	confLock.Lock()
+	confTrustedProxies = proxies
+	confLock.Unlock()
+	defer func() {
+		confLock.Lock()
+		confTrustedProxies = nil
+		confLock.Unlock()
+	}()
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		headers    map[string]string
+		want       forwardedRequest
+	}{
+		{"direct", "192.168.1.1:1234", false, nil, forwardedRequest{"192.168.1.1", false, "example.com", ""}},
+		{"direct tls", "192.168.1.1:1234", true, nil, forwardedRequest{"192.168.1.1", true, "example.com", ""}},
+		{"untrusted", "192.168.1.1:1234", false, map[string]string{
+			"X-Forwarded-For":   "1.2.3.4",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "evil.com",
+			"X-Real-IP":         "1.2.3.5",
+		}, forwardedRequest{"192.168.1.1", false, "example.com", ""}},
+		{"x-forwarded", "10.0.0.1:1234", false, map[string]string{
+			"X-Forwarded-For":   "6.6.6.6, 1.2.3.4, 10.0.0.2",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "www.example.com",
+		}, forwardedRequest{"1.2.3.4", true, "www.example.com", "443"}},
+		{"x-forwarded port", "10.0.0.1:1234", false, map[string]string{
+			"X-Forwarded-For":   "1.2.3.4",
+			"X-Forwarded-Proto": "http, https",
+			"X-Forwarded-Port":  "8443",
+		}, forwardedRequest{"1.2.3.4", true, "example.com", "8443"}},
+		{"x-real-ip", "10.0.0.1:1234", false, map[string]string{
+			"X-Real-IP": "1.2.3.4",
+		}, forwardedRequest{"1.2.3.4", false, "example.com", ""}},
+		{"forwarded", "10.0.0.1:1234", false, map[string]string{
+			"Forwarded":       `for=6.6.6.6;proto=http, for="[2001:db8::17]:4711";proto=https;host="www.example.com:8443", for=10.0.0.2`,
+			"X-Forwarded-For": "1.2.3.4",
+		}, forwardedRequest{"2001:db8::17", true, "www.example.com", "8443"}},
+		{"forwarded unknown", "10.0.0.1:1234", false, map[string]string{
+			"Forwarded": `for=unknown, for=10.0.0.2;proto=https`,
+		}, forwardedRequest{"10.0.0.2", true, "example.com", "443"}},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		r.RemoteAddr = v.remoteAddr
+		if v.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		for k, h := range v.headers {
+			r.Header.Set(k, h)
+		}
+		if got := forwarded(r); got != v.want {
+			t.Errorf("%s: got %+v, want %+v", v.name, got, v.want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/ti8/p", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "www.example.com")
+	env := makeEnvParams(r, "", "-", "/")
+	for k, want := range map[string]string{
+		"REMOTE_ADDR":        "1.2.3.4",
+		"REMOTE_HOST":        "1.2.3.4",
+		"HTTPS":              "Y",
+		"SERVER_PORT_SECURE": "1",
+		"SERVER_NAME":        "www.example.com",
+		"HTTP_HOST":          "www.example.com",
+		"SERVER_PORT":        "443",
+		"REQUEST_PROTOCOL":   "HTTPS",
+	} {
+		if env[k] != want {
+			t.Errorf("%s: got \"%s\", want \"%s\"", k, env[k], want)
+		}
+	}
+	if id := makeHandlerID(true, "u", "p", "", r); id != "U|P|1.2.3.4|" {
+		t.Errorf("Got handler id \"%s\"", id)
+	}
+}
@@ -0,0 +1,91 @@
+// headers
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	headerSet         = "set"
+	headerAdd         = "add"
+	headerRemove      = "remove"
+	headerSetIfAbsent = "setIfAbsent"
+)
+
+// headerRule - правило изменения HTTP заголовка.
+// Action: "set" (по умолчанию), "add", "remove", "setIfAbsent"
+type headerRule struct {
+	Action string
+	Name   string
+	Value  string
+}
+
+func applyHeaderRules(h http.Header, rules []headerRule) {
+	for _, v := range rules {
+		switch strings.ToLower(v.Action) {
+		case "", strings.ToLower(headerSet):
+			h.Set(v.Name, v.Value)
+		case strings.ToLower(headerAdd):
+			h.Add(v.Name, v.Value)
+		case strings.ToLower(headerRemove):
+			h.Del(v.Name)
+		case strings.ToLower(headerSetIfAbsent):
+			if h.Get(v.Name) == "" {
+				h.Set(v.Name, v.Value)
+			}
+		}
+	}
+}
+
+// validHeaderAction проверяет, что действие правила известно
+func validHeaderAction(action string) bool {
+	switch strings.ToLower(action) {
+	case "", strings.ToLower(headerSet), strings.ToLower(headerAdd), strings.ToLower(headerRemove), strings.ToLower(headerSetIfAbsent):
+		return true
+	}
+	return false
+}
+
+// headerWriter изменяет заголовки ответа непосредственно перед их отправкой,
+// после того как обработчик (например, процедура PL/SQL) установил свои
+type headerWriter struct {
+	http.ResponseWriter
+	apply       func(h http.Header)
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.apply(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// serve вызывает handle. Если обработчик ничего не записал, заголовки изменяются после его завершения
+func (w *headerWriter) serve(handle httprouter.Handle, r *http.Request, p httprouter.Params) {
+	handle(w, r, p)
+	if !w.wroteHeader {
+		w.apply(w.ResponseWriter.Header())
+	}
+}
+
+// newResponseHeaders применяет правила rules к заголовкам каждого ответа handle
+func newResponseHeaders(handle httprouter.Handle, rules []headerRule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		hw := &headerWriter{ResponseWriter: w, apply: func(h http.Header) {
+			applyHeaderRules(h, rules)
+		}}
+		hw.serve(handle, r, p)
+	}
+}
@@ -0,0 +1,72 @@
+// headers_test
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	rules := []headerRule{
+		{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+		{Action: "setIfAbsent", Name: "X-Frame-Options", Value: "DENY"},
+		{Action: "add", Name: "Cache-Control", Value: "no-store"},
+		{Action: "remove", Name: "Server"},
+		{Action: "Remove", Name: "X-Powered-By"},
+	}
+
+	var tests = []struct {
+		name    string
+		backend httprouter.Handle
+		code    int
+		want    map[string][]string
+	}{
+		{"write", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			// Заголовки, установленные процедурой PL/SQL
+			w.Header().Add("Server", "Oracle")
+			w.Header().Add("X-Powered-By", "PL/SQL")
+			w.Header().Add("X-Frame-Options", "SAMEORIGIN")
+			w.Header().Add("Cache-Control", "private")
+			w.Write([]byte("ok"))
+		}, http.StatusOK, map[string][]string{
+			"Strict-Transport-Security": {"max-age=31536000"},
+			"X-Frame-Options":           {"SAMEORIGIN"},
+			"Cache-Control":             {"private", "no-store"},
+			"Server":                    nil,
+			"X-Powered-By":              nil,
+		}},
+		{"redirect", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Location", "/next")
+			w.WriteHeader(http.StatusFound)
+		}, http.StatusFound, map[string][]string{
+			"Strict-Transport-Security": {"max-age=31536000"},
+			"X-Frame-Options":           {"DENY"},
+			"Location":                  {"/next"},
+		}},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Header().Set("Server", "Oracle")
+		}, http.StatusOK, map[string][]string{
+			"Strict-Transport-Security": {"max-age=31536000"},
+			"X-Frame-Options":           {"DENY"},
+			"Cache-Control":             {"no-store"},
+			"Server":                    nil,
+		}},
+	}
+
+	for _, v := range tests {
+		w := httptest.NewRecorder()
+		newResponseHeaders(v.backend, rules)(w, httptest.NewRequest("GET", "/", nil), nil)
+		if w.Code != v.code {
+			t.Errorf("%s: code = %d, want %d", v.name, w.Code, v.code)
+		}
+		for k, want := range v.want {
+			if got := w.HeaderMap[k]; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s = %q, want %q", v.name, k, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// health
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/goracle.v1/oracle"
+)
+
+// healthy - сервер запущен и не останавливается
+var healthy int32
+
+const (
+	// readyPingTimeout - время ожидания подключения к БД при глубокой проверке готовности
+	readyPingTimeout = 5 * time.Second
+	// readyDeepCacheTTL - время, в течение которого повторные глубокие проверки не подключаются к БД
+	readyDeepCacheTTL = 5 * time.Second
+)
+
+// Результат последней глубокой проверки
+var (
+	deepLock    sync.Mutex
+	deepTargets []readyTarget
+	deepChecks  []healthCheck
+	deepTime    time.Time
+)
+
+// readyTarget - подключение к БД, которое проверяется /readyz?deep=1
+type readyTarget struct {
+	Handler  string
+	UserName string
+	Password string
+	ConnStr  string
+}
+
+type healthCheck struct {
+	Name string
+	OK   bool
+	// Info - проверка носит информационный характер и не влияет на итоговый статус
+	Info  bool   `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+type healthStatus struct {
+	Status string
+	Checks []healthCheck
+}
+
+// pingDB подключается к БД и сразу отключается
+var pingDB = func(userName, password, connStr string) error {
+	conn, err := oracle.NewConnection(userName, password, connStr, false)
+	if conn != nil {
+		defer conn.Free(true)
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// handlerReadyTargets возвращает подключения обработчика OWA (по пользователю по умолчанию в каждой группе) или SOAP
+func handlerReadyTargets(h *handlerConfigHolder) []readyTarget {
+	switch h.Type {
+	case "owa_apex", "owa_classic", "owa_ekb":
+		if h.DefUserName == "" {
+			return nil
+		}
+		if conectionString != nil && *conectionString != "" {
+			return []readyTarget{{h.Path, h.DefUserName, h.DefUserPass, *conectionString}}
+		}
+		res := make([]readyTarget, 0, len(h.Grps))
+		sids := make(map[string]bool)
+		for _, v := range h.Grps {
+			if v.SID == "" || sids[v.SID] {
+				continue
+			}
+			sids[v.SID] = true
+			res = append(res, readyTarget{h.Path, h.DefUserName, h.DefUserPass, v.SID})
+		}
+		return res
+	case "SOAP":
+		return []readyTarget{{h.Path, h.SoapUserName, h.SoapUserPass, h.SoapConnStr}}
+	}
+	return nil
+}
+
+func newHealthCheck(name string, err error) healthCheck {
+	if err != nil {
+		return healthCheck{Name: name, Error: err.Error()}
+	}
+	return healthCheck{Name: name, OK: true}
+}
+
+func writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	res := healthStatus{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, v := range checks {
+		if !v.OK && !v.Info {
+			res.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	buf, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// healthz - процесс жив и основной слушатель принимает соединения
+func healthz(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if mainListener.currentListener() == nil {
+		err = errors.New("main listener is not started")
+	}
+	writeHealth(w, []healthCheck{newHealthCheck("listener", err)})
+}
+
+// readyz - сервер может обслуживать запросы: запущен, конфигурация загружена и источник конфигурации доступен.
+// Если Http.ReadyRequiresReader = false, состояние источника выводится для информации и сервер, работающий
+// на кэшированной конфигурации, остается готовым
+func readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, readyChecks())
+}
+
+// debugReadyz доступна только на отладочном порту. С параметром deep=1 дополнительно
+// проверяются подключения к БД обработчиков
+func debugReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := readyChecks()
+	if r.FormValue("deep") == "1" || r.FormValue("deep") == "true" {
+		confLock.RLock()
+		targets := confReadyTargets
+		confLock.RUnlock()
+		checks = append(checks, deepReadyChecks(targets)...)
+	}
+	writeHealth(w, checks)
+}
+
+func readyChecks() []healthCheck {
+	checks := make([]healthCheck, 0)
+
+	var err error
+	if atomic.LoadInt32(&healthy) == 0 {
+		err = errors.New("server is not started or is shutting down")
+	}
+	checks = append(checks, newHealthCheck("server", err))
+
+	confLock.RLock()
+	loaded := confServerReaded
+	requireReader := confHTTPReadyReader
+	confLock.RUnlock()
+
+	err = nil
+	if !loaded {
+		err = errors.New("configuration is not loaded")
+	}
+	checks = append(checks, newHealthCheck("config", err))
+
+	err = nil
+	if s := getReaderState(); s.Source != sourceFile && s.ActiveDSN == "" {
+		err = errors.New("configuration reader is not connected")
+		if s.LastError != "" {
+			err = errors.New(s.LastError)
+		}
+	}
+	check := newHealthCheck("reader", err)
+	check.Info = !requireReader
+	checks = append(checks, check)
+	return checks
+}
+
+// deepReadyChecks возвращает результат проверки подключений к БД. Результат кэшируется на readyDeepCacheTTL,
+// одновременные запросы дожидаются одной проверки
+func deepReadyChecks(targets []readyTarget) []healthCheck {
+	deepLock.Lock()
+	defer deepLock.Unlock()
+	if time.Since(deepTime) < readyDeepCacheTTL && reflect.DeepEqual(deepTargets, targets) {
+		return deepChecks
+	}
+	deepChecks = pingTargets(targets)
+	deepTargets = targets
+	deepTime = time.Now()
+	return deepChecks
+}
+
+func pingTargets(targets []readyTarget) []healthCheck {
+	res := make([]healthCheck, len(targets))
+	var wg sync.WaitGroup
+	for k, v := range targets {
+		wg.Add(1)
+		go func(k int, v readyTarget) {
+			defer wg.Done()
+			// Имя пользователя и строка подключения не выводятся
+			name := "db " + v.Handler
+			done := make(chan error, 1)
+			go func() {
+				done <- pingDB(v.UserName, v.Password, v.ConnStr)
+			}()
+			select {
+			case err := <-done:
+				res[k] = newHealthCheck(name, err)
+			case <-time.After(readyPingTimeout):
+				res[k] = newHealthCheck(name, errors.New("connection timeout"))
+			}
+		}(k, v)
+	}
+	wg.Wait()
+	return res
+}
+
+// withHealth отвечает на /healthz и /readyz до передачи запроса роутеру.
+// Глубокая проверка на основном порту не выполняется, она доступна только на отладочном
+func withHealth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			healthz(w, r)
+		case "/readyz":
+			readyz(w, r)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
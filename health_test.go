@@ -0,0 +1,133 @@
+// health_test
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	savedPing := pingDB
+	pings := 0
+	pingDB = func(userName, password, connStr string) error {
+		pings++
+		if connStr == "bad" {
+			return errors.New("ORA-12154")
+		}
+		return nil
+	}
+	defer func() { pingDB = savedPing }()
+
+	h := withHealth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("router"))
+	}))
+	get := func(url string) (int, healthStatus) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		var res healthStatus
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz without listener: got %d", code)
+	}
+	if err := mainListener.apply(freePort(t), false, time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer mainListener.apply(0, false, 0, 0)
+	if code, res := get("/healthz"); code != http.StatusOK || res.Status != "ok" {
+		t.Errorf("healthz: got %d %+v", code, res)
+	}
+
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before start: got %d", code)
+	}
+
+	h1 := handlerConfigHolder{Path: "/ti8", Type: "owa_classic", DefUserName: "u1", DefUserPass: "p1"}
+	h1.Grps = append(h1.Grps, struct {
+		ID  int32
+		SID string
+	}{1, "db"}, struct {
+		ID  int32
+		SID string
+	}{2, "db"})
+	h2 := handlerConfigHolder{Path: "/s", Type: "SOAP", SoapUserName: "u2", SoapConnStr: "bad"}
+	targets := append(handlerReadyTargets(&h1), handlerReadyTargets(&h2)...)
+	if len(targets) != 2 {
+		t.Fatalf("Got %d targets, want 2: %+v", len(targets), targets)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	confLock.Lock()
+	confServerReaded, confReadyTargets = true, targets
+	confLock.Unlock()
+	savedState := getReaderState()
+	setReaderState(func(s *readerStatus) { s.Source = sourceFile })
+	defer func() {
+		atomic.StoreInt32(&healthy, 0)
+		confLock.Lock()
+		confServerReaded, confReadyTargets = false, nil
+		confLock.Unlock()
+		setReaderState(func(s *readerStatus) { *s = savedState })
+	}()
+
+	if code, res := get("/readyz"); code != http.StatusOK || len(res.Checks) != 3 {
+		t.Errorf("readyz: got %d %+v", code, res)
+	}
+	// На основном порту глубокая проверка не выполняется
+	if code, res := get("/readyz?deep=1"); code != http.StatusOK || len(res.Checks) != 3 || pings != 0 {
+		t.Errorf("readyz deep on main listener: got %d %+v, %d ping(s)", code, res, pings)
+	}
+	getDebug := func(url string) (int, healthStatus) {
+		w := httptest.NewRecorder()
+		debugReadyz(w, httptest.NewRequest("GET", url, nil))
+		var res healthStatus
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+	code, res := getDebug("/readyz?deep=1")
+	if code != http.StatusServiceUnavailable || len(res.Checks) != 5 || !res.Checks[3].OK || res.Checks[4].Error != "ORA-12154" {
+		t.Errorf("readyz deep: got %d %+v", code, res)
+	}
+	if res.Checks[3].Name != "db /ti8" || res.Checks[4].Name != "db /s" {
+		t.Errorf("readyz deep: check names should contain handler path only, got %+v", res.Checks)
+	}
+	// Повторная проверка берется из кэша
+	if getDebug("/readyz?deep=1"); pings != 2 {
+		t.Errorf("readyz deep: repeated check should be cached, got %d ping(s)", pings)
+	}
+
+	// По умолчанию недоступность БД конфигурации делает сервер неготовым
+	setReaderState(func(s *readerStatus) { s.Source, s.ActiveDSN, s.LastError = sourceCache, "", "ORA-12541" })
+	if code, res := get("/readyz"); code != http.StatusServiceUnavailable || res.Checks[2].OK || res.Checks[2].Info {
+		t.Errorf("readyz with config DB down: got %d %+v", code, res)
+	}
+	// Http.ReadyRequiresReader = false: сервер на кэшированной конфигурации остается готовым
+	confLock.Lock()
+	confHTTPReadyReader = false
+	confLock.Unlock()
+	if code, res := get("/readyz"); code != http.StatusOK || res.Checks[2].OK || !res.Checks[2].Info {
+		t.Errorf("readyz with config DB down and reader not required: got %d %+v", code, res)
+	}
+	confLock.Lock()
+	confHTTPReadyReader = true
+	confLock.Unlock()
+
+	// Во время остановки сервер не готов
+	atomic.StoreInt32(&healthy, 0)
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz while shutting down: got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/ti8/p", nil))
+	if w.Body.String() != "router" {
+		t.Errorf("Other paths should be passed to router, got \"%s\"", w.Body.String())
+	}
+}
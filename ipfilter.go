@@ -0,0 +1,104 @@
+// ipfilter
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ipList - список сетей. Отдельный адрес задается как сеть из одного адреса
+type ipList []*net.IPNet
+
+func parseIPList(list []string) (ipList, error) {
+	res := make(ipList, 0, len(list))
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address \"%s\"", v)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network \"%s\"", v)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter - ограничение доступа по адресу клиента.
+// Адреса из deny запрещены всегда, при непустом allow разрешены только адреса из него
+type ipFilter struct {
+	allow ipList
+	deny  ipList
+}
+
+// newIPFilter возвращает nil, если ограничений нет
+func newIPFilter(allow, deny []string) (*ipFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	var (
+		f   ipFilter
+		err error
+	)
+	if f.allow, err = parseIPList(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseIPList(deny); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (f *ipFilter) allowed(addr string) bool {
+	if f == nil {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if f.deny.contains(ip) {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow.contains(ip)
+}
+
+func responseForbidden(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(w, "Forbidden")
+}
+
+// newIPRestriction разрешает запросы к обработчику handle только с адресов, допущенных фильтром f
+func newIPRestriction(handle httprouter.Handle, f *ipFilter) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if !f.allowed(clientIP(r)) {
+			responseForbidden(w)
+			return
+		}
+		handle(w, r, p)
+	}
+}
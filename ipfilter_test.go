@@ -0,0 +1,101 @@
+// ipfilter_test
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := newIPFilter([]string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		addr string
+		want bool
+	}{
+		{"10.2.3.4", true},
+		{"10.1.2.3", false},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"fd00::1", true},
+		{"::1", false},
+		{"", false},
+	}
+	for _, v := range tests {
+		if got := f.allowed(v.addr); got != v.want {
+			t.Errorf("%s: got %v, want %v", v.addr, got, v.want)
+		}
+	}
+
+	if f, err := newIPFilter(nil, nil); f != nil || err != nil || !f.allowed("1.2.3.4") {
+		t.Errorf("Empty lists should allow everything: %v %v", f, err)
+	}
+	if f, _ := newIPFilter(nil, []string{"1.2.3.4"}); f.allowed("1.2.3.4") || !f.allowed("1.2.3.5") {
+		t.Error("Deny list only: wrong result")
+	}
+	if _, err := newIPFilter([]string{"10.0.0.0/40"}, nil); err == nil {
+		t.Error("Invalid network should be error")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseIPList([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	confLock.Lock()
+	confTrustedProxies = proxies
+	confLock.Unlock()
+	defer func() {
+		confLock.Lock()
+		confTrustedProxies = nil
+		confLock.Unlock()
+	}()
+
+	var tests = []struct {
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"192.168.1.1:1234", nil, "192.168.1.1"},
+		{"192.168.1.1:1234", []string{"1.2.3.4"}, "192.168.1.1"},
+		{"10.0.0.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"6.6.6.6", "1.2.3.4:5678"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = v.remoteAddr
+		for _, h := range v.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := clientIP(r); got != v.want {
+			t.Errorf("%s %v: got \"%s\", want \"%s\"", v.remoteAddr, v.xff, got, v.want)
+		}
+	}
+
+	f, _ := newIPFilter([]string{"1.2.3.4"}, nil)
+	handle := newIPRestriction(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Write([]byte("ok"))
+	}, f)
+	for _, v := range []struct {
+		xff  string
+		code int
+	}{{"1.2.3.4", http.StatusOK}, {"1.2.3.5", http.StatusForbidden}} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", v.xff)
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		if w.Code != v.code {
+			t.Errorf("%s: got %d, want %d", v.xff, w.Code, v.code)
+		}
+	}
+}
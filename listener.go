@@ -0,0 +1,235 @@
+// listener
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+// connListener - net.Listener, в который соединения передаются из цикла приема httpListener.
+// Закрытие connListener не закрывает сокет, поэтому старый http.Server может быть остановлен
+// без потери соединений, уже принятых для нового
+type connListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// httpListener - HTTP сервер, порт, SSL и таймауты которого можно менять без остановки обслуживания.
+// При изменении параметров запускается новый http.Server, а старый останавливается через Shutdown,
+// дожидаясь завершения уже выполняющихся запросов
+type httpListener struct {
+	name      string
+	handler   http.Handler
+	connState func(net.Conn, http.ConnState)
+	tlsConfig *tls.Config
+
+	mu           sync.Mutex
+	port         int
+	ssl          bool
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	ln           net.Listener
+	current      *connListener
+	srv          *http.Server
+	// proxyFrom - адреса, соединения с которых начинаются с заголовка PROXY protocol
+	proxyFrom ipList
+}
+
+// setProxyProtocol задает адреса балансировщиков, передающих адрес клиента по PROXY protocol.
+// Действует для новых соединений
+func (s *httpListener) setProxyProtocol(from ipList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proxyFrom = from
+}
+
+func (s *httpListener) proxyProtocolFrom(addr net.Addr) bool {
+	s.mu.Lock()
+	proxyFrom := s.proxyFrom
+	s.mu.Unlock()
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && proxyFrom.contains(tcpAddr.IP)
+}
+
+func (s *httpListener) apply(port int, ssl bool, readTimeout, writeTimeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.srv != nil && s.port == port && s.ssl == ssl && s.readTimeout == readTimeout && s.writeTimeout == writeTimeout {
+		return nil
+	}
+	if port == 0 {
+		s.stop()
+		return nil
+	}
+
+	ln := s.ln
+	if ln == nil || s.port != port {
+		var err error
+		if ln, err = net.Listen("tcp", fmt.Sprintf(":%d", port)); err != nil {
+			return err
+		}
+	}
+	if ssl {
+		logInfof("%s listener starting on port \"%d\" with SSL support\n", s.name, port)
+	} else {
+		logInfof("%s listener starting on port \"%d\"\n", s.name, port)
+	}
+
+	cl := newConnListener(ln.Addr())
+	srv := &http.Server{
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		ConnState:    s.connState,
+		Handler:      s.handler,
+	}
+	var l net.Listener = cl
+	if ssl {
+		l = tls.NewListener(cl, s.tlsConfig)
+	}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed && err != errListenerClosed {
+			logError(err)
+		}
+	}()
+
+	oldLn, oldSrv := s.ln, s.srv
+	s.port, s.ssl, s.readTimeout, s.writeTimeout = port, ssl, readTimeout, writeTimeout
+	s.current, s.srv = cl, srv
+	if ln != oldLn {
+		s.ln = ln
+		go s.acceptLoop(ln)
+		if oldLn != nil {
+			oldLn.Close()
+		}
+	}
+	if oldSrv != nil {
+		go oldSrv.Shutdown(context.Background())
+	}
+	return nil
+}
+
+// stop закрывает сокет и останавливает сервер, дожидаясь завершения выполняющихся запросов.
+// Вызывается под s.mu
+func (s *httpListener) stop() {
+	if s.ln != nil {
+		s.ln.Close()
+		s.ln = nil
+	}
+	if s.srv != nil {
+		go s.srv.Shutdown(context.Background())
+		s.srv = nil
+	}
+	s.current = nil
+	s.port = 0
+}
+
+// shutdown закрывает сокет и останавливает сервер, дожидаясь завершения выполняющихся запросов
+// не дольше, чем позволяет ctx. По истечении ctx оставшиеся соединения закрываются
+func (s *httpListener) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.srv
+	s.srv = nil
+	s.stop()
+	s.mu.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("%s listener shutdown: %s", s.name, err)
+	}
+	return nil
+}
+
+func (s *httpListener) currentListener() *connListener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+func (s *httpListener) acceptLoop(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		if !s.proxyProtocolFrom(c.RemoteAddr()) {
+			s.dispatch(c)
+			continue
+		}
+		// Заголовок читается вне цикла приема, чтобы медленный клиент не задерживал остальные соединения
+		go func(c net.Conn) {
+			pc, err := readProxyHeader(c, proxyHeaderTimeout)
+			if err != nil {
+				logError(fmt.Sprintf("%s listener: %s from %s", s.name, err, c.RemoteAddr()))
+				c.Close()
+				return
+			}
+			s.dispatch(pc)
+		}(c)
+	}
+}
+
+// dispatch передает соединение текущему серверу.
+// Если сервер был заменен в момент передачи, соединение передается новому
+func (s *httpListener) dispatch(c net.Conn) {
+	for {
+		cl := s.currentListener()
+		if cl == nil {
+			c.Close()
+			return
+		}
+		select {
+		case cl.conns <- c:
+			return
+		case <-cl.closed:
+			if s.currentListener() == cl {
+				c.Close()
+				return
+			}
+		}
+	}
+}
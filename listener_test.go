@@ -0,0 +1,140 @@
+// listener_test
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func getBody(port int) (string, error) {
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	return string(buf), err
+}
+
+func TestHTTPListenerApply(t *testing.T) {
+	release := make(chan struct{})
+	s := &httpListener{
+		name: "Test",
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("wait") != "" {
+				<-release
+			}
+			w.Write([]byte("ok"))
+		}),
+	}
+	port1, port2 := freePort(t), freePort(t)
+
+	if err := s.apply(port1, false, time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := getBody(port1); err != nil || body != "ok" {
+		t.Fatalf("port %d: got \"%s\", %v", port1, body, err)
+	}
+
+	// Запрос, выполняющийся во время замены сервера, должен завершиться
+	inFlight := make(chan error, 1)
+	go func() {
+		client := http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port1) + "/?wait=1")
+		if err == nil {
+			resp.Body.Close()
+		}
+		inFlight <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := s.apply(port1, false, 2*time.Second, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := getBody(port1); err != nil || body != "ok" {
+		t.Fatalf("port %d after timeout change: got \"%s\", %v", port1, body, err)
+	}
+
+	if err := s.apply(port2, false, 2*time.Second, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := getBody(port2); err != nil || body != "ok" {
+		t.Fatalf("port %d: got \"%s\", %v", port2, body, err)
+	}
+	if _, err := getBody(port1); err == nil {
+		t.Fatalf("port %d should be closed", port1)
+	}
+
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Fatalf("in-flight request: %v", err)
+	}
+
+	if err := s.apply(0, false, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getBody(port2); err == nil {
+		t.Fatalf("port %d should be closed", port2)
+	}
+}
+
+func TestHTTPListenerShutdown(t *testing.T) {
+	release := make(chan struct{})
+	s := &httpListener{
+		name: "Test",
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.Write([]byte("ok"))
+		}),
+	}
+	port := freePort(t)
+	if err := s.apply(port, false, time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	inFlight := make(chan error, 1)
+	go func() {
+		body, err := getBody(port)
+		if err == nil && body != "ok" {
+			err = errors.New("got \"" + body + "\"")
+		}
+		inFlight <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Выполняющийся запрос должен завершиться, новые соединения не принимаются
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		done <- s.shutdown(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(port), time.Second); err == nil {
+		t.Errorf("port %d should be closed", port)
+	}
+
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Fatalf("in-flight request: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,13 +3,10 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/vsdutka/metrics"
@@ -40,50 +37,41 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		w.status = 200
 	}
-	w.length = len(b)
-	return w.ResponseWriter.Write(b)
+	n, err := w.ResponseWriter.Write(b)
+	w.length += n
+	return n, err
 }
 
-var logChan = make(chan string, 10000)
+var (
+	logChan       = make(chan string, 10000)
+	logReopenChan = make(chan struct{}, 1)
+	logFlushChan  = make(chan chan struct{})
+)
 
 func init() {
 	go func() {
-		const fmtFileName = "${log_dir}/ex${date}.log"
-		var (
-			lastLogging = time.Time{}
-			logFile     *os.File
-		)
-		defer func() {
-			if logFile != nil {
-				logFile.Close()
+		l := &rotatingLog{options: currentLogOptions}
+		defer l.close()
+		write := func(str string) {
+			if err := l.write(str); err != nil {
+				logError(err)
 			}
-		}()
+		}
 		for {
 			select {
-			case str := <-logChan:
+			case <-logReopenChan:
+				l.close()
+			case done := <-logFlushChan:
 				{
-					if lastLogging.Format("2006_01_02") != time.Now().Format("2006_01_02") {
-						if logFile != nil {
-							logFile.Close()
-						}
-						fileName := expandFileName(fmtFileName)
-						dir, _ := filepath.Split(fileName)
-						os.MkdirAll(dir, os.ModeDir)
-
-						var err error
-
-						logFile, err = os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-						if err != nil {
-							logError(err)
-							continue
-						}
+					// Записываем все накопившиеся сообщения
+					for n := len(logChan); n > 0; n-- {
+						write(<-logChan)
 					}
-					lastLogging = time.Now()
-					if _, err := logFile.WriteString(str); err != nil {
-						logError(err)
-					}
-					fmt.Print(str)
+					l.sync()
+					close(done)
 				}
+			case str := <-logChan:
+				write(str)
 			}
 		}
 	}()
@@ -92,12 +80,67 @@ func writeToLog(msg string) {
 	logChan <- msg
 }
 
+// reopenLog закрывает текущий файл лога. Следующая запись откроет файл заново, например в новом каталоге
+func reopenLog() {
+	select {
+	case logReopenChan <- struct{}{}:
+	default:
+	}
+}
+
+// flushLog дожидается записи в файл всех сообщений, переданных в лог, но не дольше timeout
+func flushLog(timeout time.Duration) {
+	done := make(chan struct{})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case logFlushChan <- done:
+	case <-timer.C:
+		return
+	}
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+type requestInfoContextKey struct{}
+
+// requestInfo - сведения о запросе, заполняемые по мере его обработки
+type requestInfo struct {
+	// vhost - имя виртуального хоста, обработавшего запрос ("" для роутера по умолчанию)
+	vhost string
+	// originalPath - исходный путь запроса, если он был приведен к нижнему регистру
+	originalPath string
+	// requestID - идентификатор запроса, возвращаемый в заголовке X-Request-Id
+	requestID string
+	// handler - путь обработчика из конфигурации
+	handler string
+	// sessionID, procName и dbDuration заполняются обработчиками OWA и SOAP
+	sessionID  string
+	procName   string
+	dbDuration time.Duration
+	// authUser - пользователь Basic авторизации, имя и пароль которого приняты БД
+	authUser string
+}
+
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoContextKey{}, info))
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey{}).(*requestInfo)
+	return info
+}
+
 type loggedHandler struct {
 	handlerFunc func() http.Handler
 }
 
 func (l *loggedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	r.URL.Path = strings.ToLower(r.URL.Path)
+	info := &requestInfo{requestID: newRequestID(r)}
+	r = withRequestInfo(r, info)
+	w.Header().Set(requestIDHeader, info.requestID)
 
 	countOfRequests.Add(1)
 	defer countOfRequests.Add(-1)
@@ -107,33 +150,18 @@ func (l *loggedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler := l.handlerFunc()
 	handler.ServeHTTP(&writer, r)
 	end := time.Now()
-	latency := end.Sub(start)
-	statusCode := writer.status
-	length := writer.length
-	user, _, ok := r.BasicAuth()
-	if !ok {
-		user = "-"
-	}
-	url := r.URL.Path
-
-	params := r.Form.Encode()
-	if params != "" {
-		url = url + "?" + params
-	}
 
-	writeToLog(fmt.Sprintf("%s, %20s, %s, %s, %12d, %12d, %8d, %d, %s, %s, %v\r\n",
-		r.RemoteAddr,
-		user,
-		end.Format("2006.01.02"),
-		end.Format("15:04:05.000000000"),
-		//r.Proto,
-		//r.Host,
-		length,
-		r.ContentLength,
-		time.Since(start)/time.Millisecond,
-		statusCode,
-		r.Method,
-		url,
-		latency,
-	))
+	format, extra := accessLogFormat()
+	writeToLog(formatAccessLog(format, extra, &accessLogEntry{
+		Time:       end,
+		Request:    r,
+		Status:     writer.status,
+		Length:     writer.length,
+		Duration:   end.Sub(start),
+		Handler:    info.handler,
+		SessionID:  info.sessionID,
+		Proc:       info.procName,
+		DBDuration: info.dbDuration,
+		RequestID:  info.requestID,
+	}))
 }
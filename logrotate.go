@@ -0,0 +1,230 @@
+// logrotate
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Периодичность смены файла лога (Http.LogRotation)
+const (
+	logRotationDaily  = "daily"
+	logRotationHourly = "hourly"
+)
+
+type logOptions struct {
+	dir      string
+	hourly   bool
+	maxSize  int64
+	compress bool
+	maxFiles int
+	maxAge   time.Duration
+	stdout   bool
+}
+
+func validLogRotation(rotation string) bool {
+	switch rotation {
+	case "", logRotationDaily, logRotationHourly:
+		return true
+	}
+	return false
+}
+
+func currentLogOptions() logOptions {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return logOptions{
+		dir:      expandFileName("${log_dir}"),
+		hourly:   confHTTPLogRotation == logRotationHourly,
+		maxSize:  int64(confHTTPLogMaxSize) << 20,
+		compress: confHTTPLogCompress,
+		maxFiles: confHTTPLogMaxFiles,
+		maxAge:   time.Duration(confHTTPLogMaxAge) * 24 * time.Hour,
+		stdout:   !confHTTPLogNoStdout,
+	}
+}
+
+// rotatingLog - файл лога ex<дата>.log, который сменяется каждый день (час) и при превышении размера.
+// Предыдущие файлы при необходимости сжимаются и удаляются по количеству и возрасту
+type rotatingLog struct {
+	options func() logOptions
+
+	opts   logOptions
+	file   *os.File
+	period string
+	size   int64
+}
+
+func (l *rotatingLog) fileName() string {
+	return filepath.Join(l.opts.dir, "ex"+l.period+".log")
+}
+
+func (l *rotatingLog) open(period string) error {
+	l.period = period
+	os.MkdirAll(l.opts.dir, os.ModeDir|0755)
+	f, err := os.OpenFile(l.fileName(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, fi.Size()
+	return nil
+}
+
+func (l *rotatingLog) close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// rotate закрывает текущий файл и возвращает его имя. Файл за прошедший период остается под своим именем,
+// файл, превысивший размер, переименовывается в ex<дата>.<n>.log
+func (l *rotatingLog) rotate(bySize bool) string {
+	name := l.fileName()
+	l.close()
+	if !bySize {
+		return name
+	}
+	base := strings.TrimSuffix(name, ".log")
+	for n := 1; ; n++ {
+		newName := fmt.Sprintf("%s.%d.log", base, n)
+		if fileExists(newName) || fileExists(newName+".gz") {
+			continue
+		}
+		if err := os.Rename(name, newName); err != nil {
+			logError("Error rotating log: ", err)
+			return ""
+		}
+		return newName
+	}
+}
+
+// archive сжимает закрытый файл name и удаляет лишние файлы
+func (l *rotatingLog) archive(name string) {
+	opts, current := l.opts, filepath.Base(l.fileName())
+	go func() {
+		logCleanupLock.Lock()
+		defer logCleanupLock.Unlock()
+		if opts.compress {
+			if err := gzipFile(name); err != nil {
+				logError("Error compressing log: ", err)
+			}
+		}
+		cleanupLogs(opts, current, time.Now())
+	}()
+}
+
+func (l *rotatingLog) periodOf(t time.Time) string {
+	if l.opts.hourly {
+		return t.Format("2006_01_02_15")
+	}
+	return t.Format("2006_01_02")
+}
+
+func (l *rotatingLog) write(str string) error {
+	now := time.Now()
+	rotated := ""
+	if l.file != nil && l.period != l.periodOf(now) {
+		rotated = l.rotate(false)
+	} else if l.file != nil && l.opts.maxSize > 0 && l.size > 0 && l.size+int64(len(str)) > l.opts.maxSize {
+		rotated = l.rotate(true)
+	}
+	if l.file == nil {
+		// Параметры лога применяются при открытии файла
+		l.opts = l.options()
+		if err := l.open(l.periodOf(now)); err != nil {
+			return err
+		}
+	}
+	if rotated != "" {
+		l.archive(rotated)
+	}
+	if l.opts.stdout {
+		fmt.Print(str)
+	}
+	n, err := l.file.WriteString(str)
+	l.size += int64(n)
+	return err
+}
+
+func (l *rotatingLog) sync() {
+	if l.file != nil {
+		l.file.Sync()
+	}
+}
+
+var logCleanupLock sync.Mutex
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	// Сжатый файл сохраняет время изменения исходного, по нему определяется возраст файла
+	os.Chtimes(name+".gz", fi.ModTime(), fi.ModTime())
+	in.Close()
+	return os.Remove(name)
+}
+
+// cleanupLogs удаляет старые файлы лога сверх opts.maxFiles (с учетом текущего) и старше opts.maxAge.
+// Текущий файл current не удаляется
+func cleanupLogs(opts logOptions, current string, now time.Time) {
+	if opts.maxFiles <= 0 && opts.maxAge <= 0 {
+		return
+	}
+	list, err := ioutil.ReadDir(opts.dir)
+	if err != nil {
+		return
+	}
+	files := make([]os.FileInfo, 0, len(list))
+	for _, fi := range list {
+		name := fi.Name()
+		if name != current && fi.Mode().IsRegular() && strings.HasPrefix(name, "ex") && (strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")) {
+			files = append(files, fi)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for k, fi := range files {
+		if (opts.maxFiles > 0 && k >= opts.maxFiles-1) || (opts.maxAge > 0 && now.Sub(fi.ModTime()) > opts.maxAge) {
+			os.Remove(filepath.Join(opts.dir, fi.Name()))
+		}
+	}
+}
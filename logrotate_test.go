@@ -0,0 +1,135 @@
+// logrotate_test
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func logFiles(t *testing.T, dir string) []string {
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, fi := range list {
+		res = append(res, fi.Name())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestRotatingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := &rotatingLog{options: func() logOptions {
+		return logOptions{dir: dir, maxSize: 100}
+	}}
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 5; i++ {
+		if err := l.write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.close()
+
+	base := "ex" + time.Now().Format("2006_01_02")
+	want := []string{base + ".1.log", base + ".2.log", base + ".log"}
+	if got := logFiles(t, dir); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Got files %v, want %v", got, want)
+	}
+	for _, v := range []struct {
+		name string
+		size int64
+	}{
+		{base + ".1.log", 80},
+		{base + ".2.log", 80},
+		{base + ".log", 40},
+	} {
+		fi, err := os.Stat(filepath.Join(dir, v.name))
+		if err != nil || fi.Size() != v.size {
+			t.Errorf("%s: got size %d, want %d (%v)", v.name, fi.Size(), v.size, err)
+		}
+	}
+}
+
+func TestGzipFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "ex2018_01_02.log")
+	if err := ioutil.WriteFile(name, []byte("line\r\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	os.Chtimes(name, mtime, mtime)
+
+	if err := gzipFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(name) {
+		t.Errorf("Source file should be removed")
+	}
+	f, err := os.Open(name + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadAll(zr)
+	if string(buf) != "line\r\n" {
+		t.Errorf("Got \"%s\"", buf)
+	}
+	if fi, _ := f.Stat(); !fi.ModTime().Equal(mtime) {
+		t.Errorf("Got mtime %v, want %v", fi.ModTime(), mtime)
+	}
+}
+
+func TestCleanupLogs(t *testing.T) {
+	var tests = []struct {
+		name     string
+		maxFiles int
+		maxAge   time.Duration
+		want     []string
+	}{
+		{"none", 0, 0, []string{"ex01.log", "ex02.log.gz", "ex03.1.log.gz", "ex04.log", "ex05.log", "other.log"}},
+		{"files", 3, 0, []string{"ex03.1.log.gz", "ex04.log", "ex05.log", "other.log"}},
+		{"age", 0, 36 * time.Hour, []string{"ex04.log", "ex05.log", "other.log"}},
+		{"both", 2, 72 * time.Hour, []string{"ex04.log", "ex05.log", "other.log"}},
+	}
+	now := time.Now()
+	for _, v := range tests {
+		dir, err := ioutil.TempDir("", "iplsgo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Файлы созданы с интервалом в сутки, ex05.log - текущий
+		for k, name := range []string{"ex01.log", "ex02.log.gz", "ex03.1.log.gz", "ex04.log", "other.log", "ex05.log"} {
+			fileName := filepath.Join(dir, name)
+			ioutil.WriteFile(fileName, nil, 0666)
+			mtime := now.Add(-time.Duration(4-k) * 24 * time.Hour)
+			os.Chtimes(fileName, mtime, mtime)
+		}
+		cleanupLogs(logOptions{dir: dir, maxFiles: v.maxFiles, maxAge: v.maxAge}, "ex05.log", now)
+		if got := logFiles(t, dir); strings.Join(got, ",") != strings.Join(v.want, ",") {
+			t.Errorf("%s: got files %v, want %v", v.name, got, v.want)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -5,24 +5,37 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 )
 
 var (
 	verFlag             *bool
+	checkConfigFlag     *bool
 	dsnFlag             *string
 	hostFlag            *string
 	confNameFlag        *string
+	confFileFlag        *string
+	confCacheFlag       *string
 	confReadTimeoutFlag *int
+	confHistoryFlag     *int
+	secretKeyFlag       *string
+	encryptFlag         *string
 	conectionString     *string
 )
 
 func setupFlags() {
 	flag.Usage = usage
 	verFlag = flag.Bool("version", false, "Show version")
-	dsnFlag = flag.String("dsn", "", "    Oracle DSN (user/passw@sid)")
+	checkConfigFlag = flag.Bool("check-config", false, "Check configuration and exit")
+	dsnFlag = flag.String("dsn", "", "    Oracle DSN (user/passw@sid). Several DSNs separated by \";\" are tried in order")
 	hostFlag = flag.String("host", "", "   Host name")
 	confNameFlag = flag.String("conf", "", "   Configuration name")
+	confFileFlag = flag.String("conf_file", "", "Configuration file (JSON or YAML), used instead of -dsn and -conf")
+	confCacheFlag = flag.String("conf_cache", "", "File for the last configuration read from DB (default \"<conf>.cache\")")
 	confReadTimeoutFlag = flag.Int("conf_tm", 10, "Configuration read timeout in seconds")
+	confHistoryFlag = flag.Int("conf_history", 10, "Number of applied configurations kept in history")
+	secretKeyFlag = flag.String("secret_key", "", "Key file for ${enc:...} secrets in configuration")
+	encryptFlag = flag.String("encrypt", "", "Encrypt value with -secret_key, print ${enc:...} reference and exit")
 	conectionString = flag.String("cs", "", "    Connection string for ALL users")
 }
 
@@ -37,3 +50,36 @@ func usage() {
 	fmt.Fprintln(os.Stderr, usageTemplate)
 	flag.PrintDefaults()
 }
+
+// startConfigReading запускает чтение конфигурации из файла, если задан -conf_file, иначе из БД
+func startConfigReading() error {
+	timeout := (time.Duration)(*confReadTimeoutFlag) * time.Second
+	applyFlags()
+	if *confFileFlag != "" {
+		return startFileReading(*confFileFlag, timeout)
+	}
+	if (*confNameFlag == "") || (*dsnFlag == "") {
+		usage()
+		os.Exit(2)
+	}
+	return startReading(*dsnFlag, *confNameFlag, timeout)
+}
+
+// applyFlags передает значения флагов в параметры чтения конфигурации
+func applyFlags() {
+	confHistorySize = *confHistoryFlag
+	secretKeyFileName = *secretKeyFlag
+	configCacheName = *confCacheFlag
+}
+
+// encryptCommand печатает ссылку на зашифрованный секрет. Возвращает код завершения процесса
+func encryptCommand() int {
+	applyFlags()
+	s, err := encryptSecret(*encryptFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encrypting value:", err)
+		return 2
+	}
+	fmt.Println(s)
+	return 0
+}
@@ -7,18 +7,13 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
-	"sync/atomic"
 	"syscall"
-	"time"
 
 	//_ "golang.org/x/tools/go/ssa"
 	"gopkg.in/goracle.v1/oracle"
 )
 
 //ВАЖНО - собирать с GODEBUG=cgocheck=0
-var (
-	healthy int32
-)
 
 func logInfof(format string, a ...interface{}) error {
 	// loggerLock.Lock()
@@ -52,12 +47,15 @@ func main() {
 		os.Exit(0)
 	}
 
-	if (*confNameFlag == "") || (*dsnFlag == "") {
-		usage()
-		os.Exit(2)
+	if *encryptFlag != "" {
+		os.Exit(encryptCommand())
+	}
+
+	if *checkConfigFlag {
+		os.Exit(checkConfigCommand())
 	}
 
-	err := startReading(*dsnFlag, *confNameFlag, (time.Duration)(*confReadTimeoutFlag)*time.Second)
+	err := startConfigReading()
 	if err != nil {
 		panic(err)
 	}
@@ -70,23 +68,12 @@ func main() {
 	go func() {
 		<-quit
 		logInfof("Server is shutting down...\n")
-		atomic.StoreInt32(&healthy, 0)
 
 		stopReading()
 		stopServer()
-
-		// ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		// defer cancel()
-
-		// server.SetKeepAlivesEnabled(false)
-		// if err := server.Shutdown(ctx); err != nil {
-		// 	logger.Fatalf("Could not gracefully shutdown the server: %v\n", err)
-		// }
 		close(done)
 	}()
 
-	atomic.StoreInt32(&healthy, 1)
-
 	startServer()
 	logInfof("Service \"%s\" is started.\n", confServiceDispName)
 
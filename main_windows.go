@@ -8,7 +8,6 @@ import (
 	"os"
 	"runtime"
 	"sync"
-	"time"
 
 	"github.com/kardianos/service"
 	//_ "golang.org/x/tools/go/ssa"
@@ -97,12 +96,15 @@ func main() {
 		os.Exit(0)
 	}
 
-	if (*confNameFlag == "") || (*dsnFlag == "") {
-		usage()
-		os.Exit(2)
+	if *encryptFlag != "" {
+		os.Exit(encryptCommand())
 	}
 
-	err := startReading(*dsnFlag, *confNameFlag, (time.Duration)(*confReadTimeoutFlag)*time.Second)
+	if *checkConfigFlag {
+		os.Exit(checkConfigCommand())
+	}
+
+	err := startConfigReading()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -115,6 +117,10 @@ func main() {
 			fmt.Sprintf("-conf=%s", *confNameFlag),
 			fmt.Sprintf("-conf_tm=%v", *confReadTimeoutFlag),
 			fmt.Sprintf("-host=%v", *hostFlag),
+			fmt.Sprintf("-conf_file=%s", *confFileFlag),
+			fmt.Sprintf("-conf_cache=%s", *confCacheFlag),
+			fmt.Sprintf("-conf_history=%v", *confHistoryFlag),
+			fmt.Sprintf("-secret_key=%s", *secretKeyFlag),
 		},
 	}
 
@@ -86,6 +86,7 @@ type oracleTasker struct {
 	connUserPass        string
 	connStr             string
 	sessID              string
+	loggedOn            bool // БД хотя бы раз приняла имя и пароль сессии
 	logRequestProceeded int
 	logErrorsNum        int
 	logSessionID        string
@@ -208,7 +209,7 @@ func (r *oracleTasker) Run(sessionID, taskID, userName, userPass, connStr,
 	if err := r.connect(userName, userPass, connStr); err != nil {
 		res.StatusCode, res.Content /*needDisconnect*/, _ = packError(err)
 		// Формируем дамп до закрытия соединения, чтобы получить корректный запрос из последнего шага
-		r.dumpError(userName, connStr, dumpErrorFileName, cgiEnv["HTTP_REFERER"], err)
+		r.dumpError(userName, connStr, dumpErrorFileName, cgiEnv["HTTP_REFERER"], cgiEnv["REQUEST_ID"], err)
 
 		//Если произошла ошибка, всегда закрываем соединение с БД
 		r.disconnect()
@@ -226,7 +227,7 @@ func (r *oracleTasker) Run(sessionID, taskID, userName, userPass, connStr,
 		cgiEnv, procName, urlParams, reqFiles); err != nil {
 		res.StatusCode, res.Content /*needDisconnect*/, _ = packError(err)
 		// Формируем дамп до закрытия соединения, чтобы получить корректный запрос из последнего шага
-		r.dumpError(userName, connStr, dumpErrorFileName, cgiEnv["HTTP_REFERER"], err)
+		r.dumpError(userName, connStr, dumpErrorFileName, cgiEnv["HTTP_REFERER"], cgiEnv["REQUEST_ID"], err)
 
 		//Если произошла ошибка, всегда закрываем соединение с БД
 		r.disconnect()
@@ -266,6 +267,7 @@ func (r *oracleTasker) connect(username, userpass, connstr string) (err error) {
 			r.connUserName = username
 			r.connUserPass = userpass
 			r.connStr = connstr
+			r.loggedOn = true
 			// Соединение с БД прошло успешно.
 			if err = r.evalSessionID(); err != nil {
 				// Если выходим с ошибкой, то в вызывающей процедуре будет вызван disconnect()
@@ -443,7 +445,7 @@ func (r *oracleTasker) run(res *OracleTaskResult, paramStoreProc, beforeScript,
 		paramValVar.SetValue(uint(key), cgiEnv[cgiEnvKeys[key]])
 
 		stmShowSetPart.WriteString(fmt.Sprintf("  l_param_name(%d) := '%s';\n", key+1, cgiEnvKeys[key]))
-		stmShowSetPart.WriteString(fmt.Sprintf("  l_param_val(%d) := '%s';\n", key+1, cgiEnv[cgiEnvKeys[key]]))
+		stmShowSetPart.WriteString(fmt.Sprintf("  l_param_val(%d) := '%s';\n", key+1, showValue(cgiEnvKeys[key], cgiEnv[cgiEnvKeys[key]])))
 		//i++
 	}
 
@@ -501,6 +503,17 @@ func (r *oracleTasker) run(res *OracleTaskResult, paramStoreProc, beforeScript,
 		"sqlerrm":          sqlErrMVar,
 		"sqlerrtrace":      sqlErrTraceVar}
 
+	// Идентификатор запроса устанавливается в client_identifier и action сессии, чтобы запрос можно было найти в v$session
+	if requestID := cgiEnv["REQUEST_ID"]; requestID != "" {
+		var requestIDVar *oracle.Variable
+		if requestIDVar, err = cur.NewVar(&requestID); err != nil {
+			return errV("request_id", requestID, err)
+		}
+		sqlParams["request_id"] = requestIDVar
+		stmExecSetPart.WriteString("  dbms_session.set_identifier(:request_id);\n  dbms_application_info.set_action(:request_id);\n")
+		stmShowSetPart.WriteString(fmt.Sprintf("  dbms_session.set_identifier('%s');\n  dbms_application_info.set_action('%s');\n", requestID, requestID))
+	}
+
 	var (
 		extParamName        []interface{}
 		extParamValue       []interface{}
@@ -636,6 +649,12 @@ func (r *oracleTasker) run(res *OracleTaskResult, paramStoreProc, beforeScript,
 
 	for key, val := range extParamValue {
 		s, _ := val.(string)
+		name, _ := extParamName[key].(string)
+		if Redacted(name) {
+			// Значение передается только через :ext_param_val и не попадает в текст запроса
+			stmShowSetPart.WriteString(fmt.Sprintf("  l_ext_param_val(%d) := '%s';\n", key+1, RedactedValue))
+			continue
+		}
 		stmExecSetPart.WriteString(fmt.Sprintf("  l_ext_param_val(%d) := '%s';\n", key+1, strings.Replace(s, "'", "''", -1)))
 		stmShowSetPart.WriteString(fmt.Sprintf("  l_ext_param_val(%d) := '%s';\n", key+1, strings.Replace(s, "'", "''", -1)))
 	}
@@ -1164,7 +1183,7 @@ func killSession(stm, username, password, sid, sessionID string) error {
 	return nil
 }
 
-func (r *oracleTasker) dumpError(userName, connStr, dumpErrorFileName, referer string, err error) {
+func (r *oracleTasker) dumpError(userName, connStr, dumpErrorFileName, referer, requestID string, err error) {
 	stm, stmShow := r.lastStms()
 	var buf bytes.Buffer
 	// BOM
@@ -1173,6 +1192,7 @@ func (r *oracleTasker) dumpError(userName, connStr, dumpErrorFileName, referer s
 	buf.WriteString(fmt.Sprintf("Строка соединения : %s\r\n", connStr))
 	buf.WriteString(fmt.Sprintf("Дата и время возникновения : %s\r\n", time.Now().Format(time.RFC1123Z)))
 	buf.WriteString(fmt.Sprintf("Referer : %s\r\n", referer))
+	buf.WriteString(fmt.Sprintf("Идентификатор запроса : %s\r\n", requestID))
 	buf.WriteString("******* Текст SQL  ********************************\r\n")
 	buf.WriteString(strings.Replace(stm, "\n", "\r\n", -1) + "\r\n")
 	buf.WriteString("******* Текст SQL закончен ************************\r\n")
@@ -1242,6 +1262,40 @@ func prepareParam(
 	stmExecSetPart, stmShowSetPart,
 	stmExecProcParams, stmShowProcParams,
 	stmExecStoreInContext, stmShowStoreInContext *bytes.Buffer,
+) error {
+	if !Redacted(paramName) {
+		return prepareTypedParam(cur, params, paramName, paramValue, paramType, paramTypeName, paramStoreProc,
+			stmExecDeclarePart, stmShowDeclarePart,
+			stmExecSetPart, stmShowSetPart,
+			stmExecProcParams, stmShowProcParams,
+			stmExecStoreInContext, stmShowStoreInContext)
+	}
+	// Значение скрываемого параметра передается через bind-переменную, в отображаемый текст запроса
+	// вместо присваивания значения выводится маска
+	var showSetPart, showStoreInContext bytes.Buffer
+	if err := prepareTypedParam(cur, params, paramName, paramValue, paramType, paramTypeName, paramStoreProc,
+		stmExecDeclarePart, stmShowDeclarePart,
+		stmExecSetPart, &showSetPart,
+		stmExecProcParams, stmShowProcParams,
+		stmExecStoreInContext, &showStoreInContext); err != nil {
+		return err
+	}
+	stmShowSetPart.WriteString(fmt.Sprintf("  l_%s := '%s';\n", paramName, RedactedValue))
+	if showStoreInContext.Len() != 0 {
+		stmShowStoreInContext.WriteString(fmt.Sprintf("  %s('%s', '%s');\n", paramStoreProc, strings.ToUpper(paramName), RedactedValue))
+	}
+	return nil
+}
+
+func prepareTypedParam(
+	cur *oracle.Cursor, params map[string]interface{},
+	paramName string, paramValue []string,
+	paramType int32, paramTypeName string,
+	paramStoreProc string,
+	stmExecDeclarePart, stmShowDeclarePart,
+	stmExecSetPart, stmShowSetPart,
+	stmExecProcParams, stmShowProcParams,
+	stmExecStoreInContext, stmShowStoreInContext *bytes.Buffer,
 ) error {
 	var (
 		lVar *oracle.Variable
@@ -1638,6 +1692,14 @@ var (
 	lf   = string([]byte{10})
 )
 
+// showValue возвращает значение параметра name для отображения в тексте запроса
+func showValue(name, val string) string {
+	if Redacted(name) {
+		return RedactedValue
+	}
+	return val
+}
+
 func trimRightCRLF(val string) string { return strings.TrimRight(val, crlf) }
 
 func removeCR(val string) string { return strings.Replace(val, cr, "", -1) }
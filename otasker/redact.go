@@ -0,0 +1,59 @@
+// redact
+package otasker
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// RedactedValue отображается вместо значений скрываемых параметров
+const RedactedValue = "******"
+
+var (
+	redactLock     sync.RWMutex
+	redactPatterns []string
+)
+
+// CheckRedactPatterns проверяет шаблоны имен скрываемых параметров
+func CheckRedactPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(strings.ToLower(p), ""); err != nil {
+			return errgo.Newf("invalid pattern \"%s\": %s", p, err)
+		}
+	}
+	return nil
+}
+
+// SetRedactedParams задает шаблоны имен параметров (например, "*pass*", "p_t0*"), значения которых
+// не отображаются в дампах ошибок, в списке сессий и в логе запросов. Имена сравниваются без учета регистра
+func SetRedactedParams(patterns []string) error {
+	if err := CheckRedactPatterns(patterns); err != nil {
+		return err
+	}
+	lower := make([]string, len(patterns))
+	for k, p := range patterns {
+		lower[k] = strings.ToLower(p)
+	}
+
+	redactLock.Lock()
+	defer redactLock.Unlock()
+	redactPatterns = lower
+	return nil
+}
+
+// Redacted возвращает true, если значение параметра name необходимо скрыть
+func Redacted(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	redactLock.RLock()
+	defer redactLock.RUnlock()
+	for _, p := range redactPatterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
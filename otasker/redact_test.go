@@ -0,0 +1,40 @@
+// redact_test
+package otasker
+
+import (
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	if err := SetRedactedParams([]string{"p_t0[1"}); err == nil {
+		t.Fatal("Invalid pattern should be rejected")
+	}
+	if err := SetRedactedParams([]string{"*PASS*", "p_t0*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRedactedParams(nil)
+
+	var tests = []struct {
+		name     string
+		redacted bool
+	}{
+		{"p_password", true},
+		{"New_Pass", true},
+		{"P_T01", true},
+		{" p_t02", true},
+		{"p_t1", false},
+		{"p_user", false},
+		{"", false},
+	}
+	for _, v := range tests {
+		if got := Redacted(v.name); got != v.redacted {
+			t.Errorf("%s: got %v, want %v", v.name, got, v.redacted)
+		}
+	}
+	if got := showValue("p_pass", "secret"); got != RedactedValue {
+		t.Errorf("Got \"%s\"", got)
+	}
+	if got := showValue("p_user", "admin"); got != "admin" {
+		t.Errorf("Got \"%s\"", got)
+	}
+}
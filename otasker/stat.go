@@ -87,13 +87,18 @@ func (r *oracleTasker) info(sortKeyName string) OracleTaskerStat {
 		idleTime = 0
 	}
 
+	password := r.logUserPass
+	if password != "" {
+		password = RedactedValue
+	}
+
 	res := OracleTaskerStat{
 		"",
 		r.logSessionID,
 		r.logTaskID,
 		r.logConnStr,
 		r.logUserName,
-		r.logUserPass,
+		password,
 		r.sessID,
 		r.stateCreateDT.Format(time.RFC3339),
 		r.logRequestProceeded,
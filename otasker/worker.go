@@ -38,6 +38,11 @@ type worker struct {
 	outChanList map[string]chan OracleTaskResult
 	startedAt   time.Time
 	started     bool
+	// loggedOn - БД приняла имя и пароль сессии. Только такие сессии учитываются в Sessions
+	loggedOn bool
+	// quitChan закрывается при остановке сервера, doneChan - после завершения сессии
+	quitChan chan struct{}
+	doneChan chan struct{}
 }
 
 func (w *worker) start() {
@@ -62,6 +67,12 @@ func (w *worker) outChan(taskID string) (chan OracleTaskResult, bool) {
 	return c, ok
 }
 
+func (w *worker) isLoggedOn() bool {
+	w.RLock()
+	defer w.RUnlock()
+	return w.loggedOn
+}
+
 func (w *worker) worked() int64 {
 	w.RLock()
 	defer w.RUnlock()
@@ -85,6 +96,7 @@ func (w *worker) listen(path, ID string, idleTimeout time.Duration) {
 		}
 		w.Unlock()
 		numberOfSessions.Add(-1)
+		close(w.doneChan)
 	}()
 
 	//	timer := acquireTimer(idleTimeout)
@@ -111,10 +123,18 @@ func (w *worker) listen(path, ID string, idleTimeout time.Duration) {
 						wrk.reqFiles,
 						wrk.dumpFileName)
 				}()
+				w.Lock()
+				w.loggedOn = w.oracleTasker.loggedOn
+				w.Unlock()
 				outChan, ok := w.outChan(wrk.taskID)
 				if ok {
 					outChan <- res
 				}
+				if res.StatusCode == StatusInvalidUsernameOrPassword {
+					// Сессия с неверным паролем не сохраняется, иначе до истечения времени простоя
+					// она занимает место в ограничении количества сессий пользователя
+					return
+				}
 				w.finish()
 				if res.StatusCode == StatusRequestWasInterrupted {
 					return
@@ -125,15 +145,31 @@ func (w *worker) listen(path, ID string, idleTimeout time.Duration) {
 			{
 				return
 			}
+		case <-w.quitChan:
+			{
+				return
+			}
 		}
 	}
 }
 
 var (
-	wlock sync.RWMutex
-	wlist = make(map[string]map[string]*worker)
+	wlock        sync.RWMutex
+	wlist        = make(map[string]map[string]*worker)
+	shuttingDown bool
 )
 
+// breakWaitTimeout - время ожидания завершения сессий после их прерывания при остановке
+const breakWaitTimeout = 10 * time.Second
+
+var shutdownResult = OracleTaskResult{StatusCode: StatusErrorPage, Content: []byte("Server is shutting down")}
+
+func isShuttingDown() bool {
+	wlock.RLock()
+	defer wlock.RUnlock()
+	return shuttingDown
+}
+
 const (
 	ClassicTasker = iota
 	ApexTasker
@@ -175,6 +211,9 @@ func Run(
 		if !ok {
 			wlock.Lock()
 			defer wlock.Unlock()
+			if shuttingDown {
+				return nil
+			}
 
 			w = &worker{
 				oracleTasker: taskerFactory[typeTasker](),
@@ -183,6 +222,8 @@ func Run(
 				outChanList:  make(map[string]chan OracleTaskResult),
 				startedAt:    time.Time{},
 				started:      false,
+				quitChan:     make(chan struct{}),
+				doneChan:     make(chan struct{}),
 			}
 			if _, ok := wlist[strings.ToUpper(path)]; !ok {
 				wlist[strings.ToUpper(path)] = make(map[string]*worker)
@@ -193,10 +234,17 @@ func Run(
 		}
 		return w
 	}()
+	if w == nil {
+		return shutdownResult
+	}
 
 	// Проверяем, если результаты по задаче
 	outChan, ok := w.outChan(taskID)
 	if !ok {
+		// При остановке сервера новые задачи не принимаются, результаты уже отправленных можно получить
+		if isShuttingDown() {
+			return shutdownResult
+		}
 		//		timer := acquireTimer(waitTimeout)
 		//		defer releaseTimer(timer)
 		//Если еще не было отправки, то проверяем на то, что можно отправит
@@ -224,7 +272,14 @@ func Run(
 				w.Lock()
 				w.outChanList[taskID] = outChan
 				w.Unlock()
-				w.inChan <- wrk
+				select {
+				case w.inChan <- wrk:
+				case <-w.quitChan:
+					w.Lock()
+					delete(w.outChanList, taskID)
+					w.Unlock()
+					return shutdownResult
+				}
 
 			}
 		case /*<-timer.C*/ <-time.After(waitTimeout):
@@ -276,3 +331,71 @@ func Break(path, sessionID string) error {
 	}
 
 }
+
+// Sessions возвращает количество сессий виртуальной директории path, идентификатор которых начинается с prefix,
+// и признак наличия сессии sessionID. Учитываются только сессии, имя и пароль которых приняты БД
+func Sessions(path, prefix, sessionID string) (int, bool) {
+	prefix = strings.ToUpper(prefix)
+	wlock.RLock()
+	defer wlock.RUnlock()
+	l := wlist[strings.ToUpper(path)]
+	_, ok := l[strings.ToUpper(sessionID)]
+	n := 0
+	for ID, w := range l {
+		if strings.HasPrefix(ID, prefix) && w.isLoggedOn() {
+			n++
+		}
+	}
+	return n, ok
+}
+
+// Shutdown завершает все сессии при остановке сервера. Новые задачи не принимаются,
+// выполняющимся дается timeout на завершение, после чего они прерываются через Break.
+// Свободные сессии закрываются сразу. Возвращает количество прерванных сессий
+func Shutdown(timeout time.Duration) int {
+	wlock.Lock()
+	if shuttingDown {
+		wlock.Unlock()
+		return 0
+	}
+	shuttingDown = true
+	workers := make([]*worker, 0)
+	for _, l := range wlist {
+		for _, w := range l {
+			workers = append(workers, w)
+		}
+	}
+	wlock.Unlock()
+
+	for _, w := range workers {
+		close(w.quitChan)
+	}
+	if waitWorkers(workers, timeout) {
+		return 0
+	}
+
+	broken := 0
+	for _, w := range workers {
+		select {
+		case <-w.doneChan:
+		default:
+			w.Break()
+			broken++
+		}
+	}
+	waitWorkers(workers, breakWaitTimeout)
+	return broken
+}
+
+func waitWorkers(workers []*worker, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, w := range workers {
+		select {
+		case <-w.doneChan:
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
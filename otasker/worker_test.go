@@ -6,6 +6,7 @@ import (
 	//	"net/http"
 	//	"net/http/httptest"
 	//	"net/http/pprof"
+	"fmt"
 	"net/url"
 	"strings"
 	"sync"
@@ -446,3 +447,105 @@ end;`,
 //  rollback;
 //end;`
 //)
+
+func TestShutdown(t *testing.T) {
+	const path, sessionID = "/TEST_SHUTDOWN", "S1"
+
+	w := &worker{
+		oracleTasker: taskerFactory[ClassicTasker](),
+		signalChan:   make(chan string, 1),
+		inChan:       make(chan work),
+		outChanList:  make(map[string]chan OracleTaskResult),
+		quitChan:     make(chan struct{}),
+		doneChan:     make(chan struct{}),
+	}
+	wlock.Lock()
+	wlist[path] = map[string]*worker{sessionID: w}
+	wlock.Unlock()
+	numberOfSessions.Add(1)
+	go w.listen(path, sessionID, time.Hour)
+
+	defer func() {
+		wlock.Lock()
+		shuttingDown = false
+		wlock.Unlock()
+	}()
+
+	if broken := Shutdown(time.Second); broken != 0 {
+		t.Errorf("Shutdown: got %d broken session(s), want 0", broken)
+	}
+	wlock.RLock()
+	_, ok := wlist[path][sessionID]
+	wlock.RUnlock()
+	if ok {
+		t.Error("Idle session should be closed")
+	}
+
+	res := Run(path, ClassicTasker, sessionID, "T1", "", "", "", "", "", "", "", nil, "", nil, nil, time.Second, time.Second, "")
+	if res.StatusCode != StatusErrorPage {
+		t.Errorf("Run after Shutdown: got status %d, want %d", res.StatusCode, StatusErrorPage)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	const path = "/TEST_SESSIONS"
+
+	wlock.Lock()
+	wlist[path] = map[string]*worker{
+		"USER1|PASS|10.0.0.1|": {loggedOn: true},
+		"USER1|PASS|10.0.0.2|": {loggedOn: true},
+		"USER10|PASS||":        {loggedOn: true},
+		"USER2|PASS||":         {loggedOn: true},
+		// Сессии, пароль которых не принят БД, не учитываются
+		"USER2|BAD1||": {},
+		"USER2|BAD2||": {},
+	}
+	wlock.Unlock()
+	defer func() {
+		wlock.Lock()
+		delete(wlist, path)
+		wlock.Unlock()
+	}()
+
+	var tests = []struct {
+		prefix    string
+		sessionID string
+		n         int
+		ok        bool
+	}{
+		{"user1|", "user1|pass|10.0.0.1|", 2, true},
+		{"USER1|", "USER1|PASS|10.0.0.3|", 2, false},
+		{"USER2|", "", 1, false},
+		{"USER3|", "", 0, false},
+	}
+	for _, v := range tests {
+		n, ok := Sessions(strings.ToLower(path), v.prefix, v.sessionID)
+		if n != v.n || ok != v.ok {
+			t.Errorf("%s %s: got %d %v, want %d %v", v.prefix, v.sessionID, n, ok, v.n, v.ok)
+		}
+	}
+}
+
+func TestSessionsWrongPassword(t *testing.T) {
+	const path = "/TEST_SESSIONS_WRONG_PASSWORD"
+	// Перебор неверных паролей не должен занимать места в ограничении количества сессий пользователя
+	for i := 0; i < 5; i++ {
+		res := Run(path, ClassicTasker, fmt.Sprintf("%s|bad%d||", dsn_user, i), fmt.Sprintf("TASK%d", i),
+			dsn_user, fmt.Sprintf("bad%d", i), dsn_sid, "WEX.WS", stm_init, "", "WWV_DOCUMENT", cgi,
+			"TestSessionsWrongPassword", url.Values{}, nil, 10*time.Second, time.Hour, ".\\log.log")
+		if res.StatusCode != StatusInvalidUsernameOrPassword {
+			t.Fatalf("%d: StatusCode - got %v, want %v", i, res.StatusCode, StatusInvalidUsernameOrPassword)
+		}
+	}
+	if n, _ := Sessions(path, dsn_user+"|", ""); n != 0 {
+		t.Errorf("Got %d session(s) with wrong password, want 0", n)
+	}
+	// Сессии с неверным паролем закрываются сразу, не дожидаясь истечения времени простоя
+	time.Sleep(time.Second)
+	wlock.RLock()
+	n := len(wlist[path])
+	wlock.RUnlock()
+	if n != 0 {
+		t.Errorf("Got %d worker(s) with wrong password, want 0", n)
+	}
+}
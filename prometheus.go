@@ -0,0 +1,195 @@
+// prometheus
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vsdutka/metrics"
+)
+
+const promPrefix = "iplsgo_"
+
+// Типы метрик Prometheus. К имени счетчика добавляется суффикс "_total"
+const (
+	promGauge   = "gauge"
+	promCounter = "counter"
+)
+
+// promExported - метрики реестра vsdutka/metrics, отдаваемые в /metrics.
+// Реестр не позволяет перечислить метрики, поэтому они перечислены здесь
+var promExported = []struct {
+	name string
+	kind string
+	help string
+}{
+	{"open_connections", promGauge, "Number of open connections"},
+	{"Http_Number_Of_Requests", promGauge, "Number of http requests in progress"},
+	{"PersistentHandler_Number_Of_Sessions", promGauge, "Number of persistent Oracle sessions"},
+	{"config_read_duration", promGauge, "Configuration read duration in seconds"},
+	{"Proxy_Number_Of_Requests", promCounter, "Number of proxied requests"},
+	{"Proxy_Number_Of_Errors", promCounter, "Number of upstream errors"},
+	{"RateLimit_Number_Of_Rejections", promCounter, "Number of requests rejected by rate limit"},
+	{"RateLimit_Number_Of_Session_Rejections", promCounter, "Number of requests rejected by session limit"},
+	{"Describe_Total_Time", promCounter, "Describe total time in nanoseconds"},
+	{"Describe_RLock_Wait_Time", promCounter, "Describe wait time to RLock in nanoseconds"},
+	{"Describe_RLock_Wait_Times", promCounter, "Describe total number of waits to RLock"},
+	{"Describe_RLock_Wait_Nums", promGauge, "Describe current number of waits to RLock"},
+	{"Describe_RLock_Wait_Time_Ave", promGauge, "Describe average wait time to RLock in nanoseconds"},
+	{"Describe_Lock_Wait_Time", promCounter, "Describe wait time to Lock in nanoseconds"},
+	{"Describe_Lock_Wait_Times", promCounter, "Describe total number of waits to Lock"},
+	{"Describe_Lock_Wait_Nums", promGauge, "Describe current number of waits to Lock"},
+	{"Describe_Lock_Wait_Time_Ave", promGauge, "Describe average wait time to Lock in nanoseconds"},
+	{"Timers_Number_Of_Used_Timers", promGauge, "Number of used timers"},
+	{"Timers_Number_Of_Creation_Of_Timer", promCounter, "Number of creation of timer"},
+	{"Timers_Number_Of_Acquiring_Of_Timers", promCounter, "Number of acquiring of timer"},
+	{"Timers_Number_Of_Releasing_Of_Timers", promCounter, "Number of releasing of timer"},
+}
+
+// promBuckets - границы корзин гистограммы длительности запросов в секундах
+var promBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type handlerStatKey struct {
+	host    string
+	handler string
+	kind    string
+	code    int
+}
+
+type handlerStat struct {
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+var (
+	handlerStatsLock sync.Mutex
+	handlerStats     = make(map[handlerStatKey]*handlerStat)
+)
+
+func observeRequest(host, handler, kind string, code int, d time.Duration) {
+	key := handlerStatKey{host, handler, kind, code}
+	seconds := d.Seconds()
+
+	handlerStatsLock.Lock()
+	defer handlerStatsLock.Unlock()
+	s, ok := handlerStats[key]
+	if !ok {
+		s = &handlerStat{buckets: make([]uint64, len(promBuckets))}
+		handlerStats[key] = s
+	}
+	s.count++
+	s.sum += seconds
+	for k, v := range promBuckets {
+		if seconds <= v {
+			s.buckets[k]++
+		}
+	}
+}
+
+// newInstrumented учитывает запросы к обработчику handle в статистике /metrics и передает путь обработчика в лог.
+// host - виртуальный хост обработчика, один и тот же путь может быть зарегистрирован для нескольких хостов
+func newInstrumented(handle httprouter.Handle, host, handler, kind string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if info := getRequestInfo(r); info != nil {
+			info.handler = handler
+		}
+		start := time.Now()
+		writer := statusWriter{w, 0, 0}
+		handle(&writer, r, p)
+		code := writer.status
+		if code == 0 {
+			code = http.StatusOK
+		}
+		observeRequest(host, handler, kind, code, time.Since(start))
+	}
+}
+
+func promName(name string) string {
+	return promPrefix + strings.ToLower(name)
+}
+
+// promLabelEscaper экранирует значение метки по правилам текстового формата Prometheus:
+// экранируются только обратная косая черта, кавычка и перевод строки, кириллица остается как есть
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(v string) string {
+	return `"` + promLabelEscaper.Replace(v) + `"`
+}
+
+func writePrometheus(w *bufio.Writer) {
+	for _, v := range promExported {
+		var value string
+		switch m := metrics.Get(v.name).(type) {
+		case *metrics.Int:
+			value = strconv.FormatInt(m.Get(), 10)
+		case *metrics.Float:
+			value = strconv.FormatFloat(m.Get(), 'g', -1, 64)
+		default:
+			continue
+		}
+		name := promName(v.name)
+		if v.kind == promCounter {
+			name += "_total"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, v.help, name, v.kind, name, value)
+	}
+
+	handlerStatsLock.Lock()
+	keys := make([]handlerStatKey, 0, len(handlerStats))
+	stats := make(map[handlerStatKey]handlerStat, len(handlerStats))
+	for k, v := range handlerStats {
+		keys = append(keys, k)
+		stats[k] = handlerStat{v.count, v.sum, append([]uint64(nil), v.buckets...)}
+	}
+	handlerStatsLock.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	labels := func(k handlerStatKey) string {
+		return fmt.Sprintf("host=%s,handler=%s,type=%s,code=\"%d\"", promLabel(k.host), promLabel(k.handler), promLabel(k.kind), k.code)
+	}
+
+	name := promName("handler_requests_total")
+	fmt.Fprintf(w, "# HELP %s Number of requests by handler\n# TYPE %s counter\n", name, name)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels(k), stats[k].count)
+	}
+
+	name = promName("handler_request_duration_seconds")
+	fmt.Fprintf(w, "# HELP %s Request duration by handler\n# TYPE %s histogram\n", name, name)
+	for _, k := range keys {
+		s := stats[k]
+		for i, b := range promBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels(k), strconv.FormatFloat(b, 'g', -1, 64), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels(k), s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels(k), strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels(k), s.count)
+	}
+}
+
+// promMetrics отдает метрики в текстовом формате Prometheus
+func promMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writePrometheus(bw)
+	bw.Flush()
+}
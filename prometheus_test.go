@@ -0,0 +1,62 @@
+// prometheus_test
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPrometheus(t *testing.T) {
+	handlerStatsLock.Lock()
+	handlerStats = make(map[handlerStatKey]*handlerStat)
+	handlerStatsLock.Unlock()
+
+	handle := newInstrumented(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if r.URL.Path == "/ti8/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}, "", "/ti8", "owa_apex")
+	for _, url := range []string{"/ti8/a", "/ti8/b", "/ti8/missing"} {
+		handle(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil), nil)
+	}
+	observeRequest("", "/s", "SOAP", http.StatusOK, 3*time.Second)
+	// Один путь на разных виртуальных хостах учитывается отдельно, кириллица в метках не экранируется
+	observeRequest("портал.рф", "/s", "SOAP", http.StatusOK, time.Second)
+	observeRequest("", "/путь\"\n", "Static", http.StatusOK, time.Second)
+
+	w := httptest.NewRecorder()
+	promMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		"# TYPE iplsgo_open_connections gauge\niplsgo_open_connections ",
+		"iplsgo_persistenthandler_number_of_sessions ",
+		"iplsgo_config_read_duration ",
+		"# TYPE iplsgo_proxy_number_of_errors_total counter\niplsgo_proxy_number_of_errors_total ",
+		"# TYPE iplsgo_ratelimit_number_of_rejections_total counter\n",
+		"# TYPE iplsgo_describe_rlock_wait_nums gauge\n",
+		`iplsgo_handler_requests_total{host="",handler="/ti8",type="owa_apex",code="200"} 2`,
+		`iplsgo_handler_requests_total{host="",handler="/ti8",type="owa_apex",code="404"} 1`,
+		`iplsgo_handler_request_duration_seconds_bucket{host="",handler="/s",type="SOAP",code="200",le="2.5"} 0`,
+		`iplsgo_handler_request_duration_seconds_bucket{host="",handler="/s",type="SOAP",code="200",le="5"} 1`,
+		`iplsgo_handler_request_duration_seconds_bucket{host="",handler="/s",type="SOAP",code="200",le="+Inf"} 1`,
+		`iplsgo_handler_request_duration_seconds_sum{host="",handler="/s",type="SOAP",code="200"} 3`,
+		`iplsgo_handler_request_duration_seconds_count{host="",handler="/ti8",type="owa_apex",code="200"} 2`,
+		`iplsgo_handler_requests_total{host="портал.рф",handler="/s",type="SOAP",code="200"} 1`,
+		`iplsgo_handler_requests_total{host="",handler="/путь\"\n",type="Static",code="200"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Output does not contain %q", want)
+		}
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Got Content-Type \"%s\"", ct)
+	}
+}
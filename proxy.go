@@ -0,0 +1,146 @@
+// proxy
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vsdutka/metrics"
+	errgo "gopkg.in/errgo.v1"
+)
+
+var (
+	proxyRequests = metrics.NewInt("Proxy_Number_Of_Requests", "Proxy - Number of proxied requests", "Items", "i")
+	proxyErrors   = metrics.NewInt("Proxy_Number_Of_Errors", "Proxy - Number of upstream errors", "Items", "i")
+)
+
+func parseUpstreams(upstreams []string) ([]*url.URL, error) {
+	if len(upstreams) == 0 {
+		return nil, errgo.New("upstreams are not set")
+	}
+	res := make([]*url.URL, 0, len(upstreams))
+	for _, v := range upstreams {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, errgo.Newf("invalid upstream \"%s\": %s", v, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errgo.Newf("invalid upstream \"%s\": absolute http(s) URL is required", v)
+		}
+		res = append(res, u)
+	}
+	return res, nil
+}
+
+// newProxy возвращает обработчик, перенаправляющий запросы на upstreams по очереди (round-robin).
+// Если stripPath, путь обработчика pathStr отбрасывается, затем добавляется pathPrefix
+func newProxy(pathStr string, upstreams []*url.URL, stripPath, preserveHost bool, pathPrefix string,
+	requestHeaders, responseHeaders []headerRule, timeout, connectTimeout time.Duration,
+) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var next uint32
+
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			target := upstreams[int(atomic.AddUint32(&next, 1)-1)%len(upstreams)]
+
+			// Заголовки от клиентов, не являющихся доверенными прокси, удаляются или заменяются.
+			// Адрес клиента добавляется в X-Forwarded-For самим ReverseProxy
+			if !fromTrustedProxy(r) {
+				r.Header.Del("Forwarded")
+				r.Header.Del("X-Forwarded-For")
+				r.Header.Del("X-Forwarded-Port")
+				r.Header.Del("X-Real-IP")
+			}
+			fwd := forwarded(r)
+			host := fwd.host
+			if fwd.port != "" {
+				host = net.JoinHostPort(fwd.host, fwd.port)
+			}
+			r.Header.Set("X-Forwarded-Host", host)
+			if fwd.https {
+				r.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				r.Header.Set("X-Forwarded-Proto", "http")
+			}
+
+			r.URL.Scheme = target.Scheme
+			r.URL.Host = target.Host
+			r.URL.Path = joinURLPath(target.Path, r.URL.Path)
+			r.URL.RawPath = ""
+			if target.RawQuery != "" {
+				if r.URL.RawQuery == "" {
+					r.URL.RawQuery = target.RawQuery
+				} else {
+					r.URL.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+				}
+			}
+			if !preserveHost {
+				r.Host = target.Host
+			}
+			applyHeaderRules(r.Header, requestHeaders)
+		},
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   connectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		ModifyResponse: func(res *http.Response) error {
+			applyHeaderRules(res.Header, responseHeaders)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyErrors.Add(1)
+			logError("proxy: ", proxyLogURL(r.URL), ": ", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		proxyRequests.Add(1)
+
+		rest := p.ByName("path")
+		if stripPath {
+			r.URL.Path = pathPrefix + rest
+		} else {
+			// Путь обработчика берется из запроса: после preservePathCase он в исходном регистре
+			base := pathStr
+			if len(r.URL.Path) >= len(pathStr) && strings.EqualFold(r.URL.Path[:len(pathStr)], pathStr) {
+				base = r.URL.Path[:len(pathStr)]
+			}
+			r.URL.Path = pathPrefix + base + rest
+		}
+		rp.ServeHTTP(w, r)
+	}
+}
+
+// proxyLogURL возвращает адрес запроса к upstream для лога. Значения скрываемых параметров (Http.RedactParams) заменяются
+func proxyLogURL(u *url.URL) string {
+	res := u.Scheme + "://" + u.Host + u.Path
+	if params := encodeLogParams(u.Query()); params != "" {
+		res += "?" + params
+	}
+	return res
+}
+
+func joinURLPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
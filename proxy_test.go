@@ -0,0 +1,161 @@
+// proxy_test
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vsdutka/iplsgo/otasker"
+)
+
+func TestProxy(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", name)
+			w.Header().Set("Server", "upstream")
+			w.Write([]byte(name + " " + r.URL.RequestURI() + " " + r.Header.Get("X-Gateway")))
+		}))
+	}
+	u1 := newUpstream("u1")
+	defer u1.Close()
+	u2 := newUpstream("u2")
+	defer u2.Close()
+
+	upstreams, err := parseUpstreams([]string{u1.URL + "/base", u2.URL + "/base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		stripPath bool
+		prefix    string
+		url       string
+		res       string
+	}{
+		{false, "", "/api/a/b?x=1", "u1 /base/api/a/b?x=1 iplsgo"},
+		{false, "", "/api/a/b?x=1", "u2 /base/api/a/b?x=1 iplsgo"},
+		{true, "", "/api/a/b", "u1 /base/a/b iplsgo"},
+		{true, "/v1", "/api/a/b", "u2 /base/v1/a/b iplsgo"},
+	}
+	rt := make(map[string]*httprouter.Router)
+	for _, v := range tests {
+		key := fmt.Sprintf("%v %s", v.stripPath, v.prefix)
+		if _, ok := rt[key]; !ok {
+			rt[key] = httprouter.New()
+			routePath, methods := handlerRoute("Proxy", "/api")
+			h := newProxy("/api", upstreams, v.stripPath, false, v.prefix,
+				[]headerRule{{Name: "X-Gateway", Value: "iplsgo"}},
+				[]headerRule{{Action: "remove", Name: "Server"}},
+				time.Second, time.Second)
+			for _, m := range methods {
+				rt[key].Handle(m, routePath, h)
+			}
+		}
+		if v.stripPath && v.prefix != "" {
+			// Первый запрос уходит на u1, проверяем второй
+			rt[key].ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", v.url, nil))
+		}
+
+		w := httptest.NewRecorder()
+		rt[key].ServeHTTP(w, httptest.NewRequest("GET", v.url, nil))
+		res, _ := ioutil.ReadAll(w.Body)
+		if string(res) != v.res {
+			t.Errorf("%s: got \"%s\", want \"%s\"", v.url, res, v.res)
+		}
+		if w.Header().Get("Server") != "" {
+			t.Errorf("%s: header \"Server\" should be removed", v.url)
+		}
+	}
+
+	// Недоступный upstream
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	upstreams, _ = parseUpstreams([]string{closed.URL})
+	h := newProxy("/api", upstreams, false, false, "", nil, nil, time.Second, time.Second)
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/api/a", nil), httprouter.Params{{Key: "path", Value: "/a"}})
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("unreachable upstream: got status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func TestProxyForwarded(t *testing.T) {
+	proxies, _ := parseIPList([]string{"10.0.0.0/8"})
+	confLock.Lock()
+	confTrustedProxies = proxies
+	confLock.Unlock()
+	defer func() {
+		confLock.Lock()
+		confTrustedProxies = nil
+		confLock.Unlock()
+	}()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-For") + "|" + r.Header.Get("Forwarded") + "|" + r.Header.Get("X-Real-IP")))
+	}))
+	defer upstream.Close()
+	upstreams, _ := parseUpstreams([]string{upstream.URL})
+	h := newProxy("/api", upstreams, false, false, "", nil, nil, time.Second, time.Second)
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{"untrusted", "192.0.2.1:1234", "192.0.2.1||"},
+		{"trusted", "10.0.0.1:1234", "6.6.6.6, 10.0.0.1|for=6.6.6.6|6.6.6.6"},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/api/a", nil)
+		r.RemoteAddr = v.remoteAddr
+		r.Header.Set("X-Forwarded-For", "6.6.6.6")
+		r.Header.Set("Forwarded", "for=6.6.6.6")
+		r.Header.Set("X-Real-IP", "6.6.6.6")
+		w := httptest.NewRecorder()
+		h(w, r, httprouter.Params{{Key: "path", Value: "/a"}})
+		if got := w.Body.String(); got != v.want {
+			t.Errorf("%s: got \"%s\", want \"%s\"", v.name, got, v.want)
+		}
+	}
+}
+
+func TestProxyPathCase(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+	defer resetConfig()
+
+	if err := parseConfig([]byte(`{"Http.Port":9979,"Http.Handlers":[
+		{"Path":"/api","Type":"Proxy","proxy.Upstreams":["` + upstream.URL + `/Base"]}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	confLock.RLock()
+	rt := router
+	confLock.RUnlock()
+
+	// Путь по умолчанию приводится к нижнему регистру для поиска маршрута, но upstream получает исходный
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/API/Items/AbC", nil))
+	if got, want := w.Body.String(), "/Base/API/Items/AbC"; got != want {
+		t.Errorf("got \"%s\", want \"%s\"", got, want)
+	}
+}
+
+func TestProxyLogURL(t *testing.T) {
+	if err := otasker.SetRedactedParams([]string{"*pass*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer otasker.SetRedactedParams(nil)
+
+	u, _ := url.Parse("http://backend:8080/api/login?user=u1&password=secret")
+	if got, want := proxyLogURL(u), "http://backend:8080/api/login?password=******&user=u1"; got != want {
+		t.Errorf("got \"%s\", want \"%s\"", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+// proxyproto
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout - время ожидания заголовка PROXY protocol после установки соединения
+const proxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn - соединение, адрес клиента которого получен из заголовка PROXY protocol
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader читает заголовок PROXY protocol версии 1 или 2.
+// Для "PROXY UNKNOWN" и команды LOCAL сохраняется адрес соединения
+func readProxyHeader(c net.Conn, timeout time.Duration) (net.Conn, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(c)
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("PROXY protocol: %s", err)
+	}
+	var addr net.Addr
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		addr, err = readProxyV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		addr, err = readProxyV1(r)
+	default:
+		err = fmt.Errorf("PROXY protocol: header is missing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, r: r, remoteAddr: addr}, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// Максимальная длина заголовка версии 1 - 107 байт
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("PROXY protocol: %s", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY protocol: invalid v1 header")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY protocol: invalid v1 header \"%s\"", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("PROXY protocol: invalid v1 source address \"%s:%s\"", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("PROXY protocol: %s", err)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("PROXY protocol: unsupported version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("PROXY protocol: %s", err)
+	}
+	switch hdr[12] & 0x0F {
+	case 0x0:
+		// LOCAL - соединение установлено самим прокси, например для проверки доступности
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("PROXY protocol: unsupported command %d", hdr[12]&0x0F)
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		// Адреса AF_UNIX и UNSPEC не содержат IP адреса клиента
+		return nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("PROXY protocol: v2 address block is too short")
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, body[:ipLen])
+	return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(body[2*ipLen:]))}, nil
+}
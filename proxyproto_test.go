@@ -0,0 +1,114 @@
+// proxyproto_test
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd, fam byte, addr []byte) string {
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(addr)))
+	return string(proxyV2Signature) + string([]byte{0x20 | cmd, fam}) + string(length[:]) + string(addr)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{1, 2, 3, 4, 10, 0, 0, 1, 0x1F, 0x90, 0x00, 0x50}
+	v6 := append(append(net.ParseIP("2001:db8::17").To16(), net.ParseIP("2001:db8::1").To16()...), 0x12, 0x67, 0x01, 0xBB)
+
+	var tests = []struct {
+		name   string
+		header string
+		want   string
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 1.2.3.4 10.0.0.1 8080 80\r\n", "1.2.3.4:8080", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::17 2001:db8::1 4711 443\r\n", "[2001:db8::17]:4711", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "pipe", false},
+		{"v1 bad family", "PROXY TCP4 2001:db8::17 10.0.0.1 8080 80\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 1.2.3.4 10.0.0.1 80800 80\r\n", "", true},
+		{"v1 no crlf", "PROXY TCP4 1.2.3.4 10.0.0.1 8080 80" + strings.Repeat(" ", 100), "", true},
+		{"v2 tcp4", proxyV2Header(1, 0x11, v4), "1.2.3.4:8080", false},
+		{"v2 tcp6 with tlv", proxyV2Header(1, 0x21, append(v6, 0x04, 0x00, 0x01, 0x00)), "[2001:db8::17]:4711", false},
+		{"v2 local", proxyV2Header(0, 0x00, nil), "pipe", false},
+		{"v2 short", proxyV2Header(1, 0x11, v4[:6]), "", true},
+		{"missing", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "", true},
+	}
+	for _, v := range tests {
+		client, server := net.Pipe()
+		go func() {
+			client.Write([]byte(v.header + "GET"))
+			client.Close()
+		}()
+		c, err := readProxyHeader(server, time.Second)
+		if (err != nil) != v.err {
+			t.Errorf("%s: got error %v", v.name, err)
+			server.Close()
+			continue
+		}
+		if err != nil {
+			server.Close()
+			continue
+		}
+		if got := c.RemoteAddr().String(); got != v.want {
+			t.Errorf("%s: got address \"%s\", want \"%s\"", v.name, got, v.want)
+		}
+		// Данные после заголовка должны быть прочитаны из соединения без потерь
+		if rest, _ := ioutil.ReadAll(c); string(rest) != "GET" {
+			t.Errorf("%s: got rest \"%s\"", v.name, rest)
+		}
+		c.Close()
+	}
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	s := &httpListener{
+		name: "Test",
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.RemoteAddr))
+		}),
+	}
+	port := freePort(t)
+	if err := s.apply(port, false, time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer s.apply(0, false, 0, 0)
+
+	get := func(header string) (string, error) {
+		c, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(port), time.Second)
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(2 * time.Second))
+		c.Write([]byte(header + "GET / HTTP/1.0\r\nHost: a\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		return string(buf), err
+	}
+
+	// Без доверенных источников заголовок не ожидается
+	if body, err := get(""); err != nil || !strings.HasPrefix(body, "127.0.0.1:") {
+		t.Errorf("Without PROXY protocol: got \"%s\", %v", body, err)
+	}
+
+	from, _ := parseIPList([]string{"127.0.0.1"})
+	s.setProxyProtocol(from)
+	if body, err := get("PROXY TCP4 1.2.3.4 127.0.0.1 5678 80\r\n"); err != nil || body != "1.2.3.4:5678" {
+		t.Errorf("With PROXY protocol: got \"%s\", %v", body, err)
+	}
+	if _, err := get(""); err == nil {
+		t.Errorf("Connection without header from trusted source should be closed")
+	}
+}
@@ -0,0 +1,222 @@
+// ratelimit
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vsdutka/metrics"
+)
+
+var (
+	rateLimitRejections    = metrics.NewInt("RateLimit_Number_Of_Rejections", "Rate limit - Number of requests rejected by rate limit", "Items", "i")
+	sessionLimitRejections = metrics.NewInt("RateLimit_Number_Of_Session_Rejections", "Rate limit - Number of requests rejected by session limit", "Items", "i")
+)
+
+// Ключ ограничения частоты запросов (RateLimitKey)
+const (
+	rateKeyIP     = "ip"
+	rateKeyUser   = "user"
+	rateKeyUserIP = "user+ip"
+)
+
+const (
+	// rateLimitTemplate - код шаблона owa.Templates для ответа 429
+	rateLimitTemplate = "ratelimit"
+	// sessionRetryAfter - значение Retry-After при превышении количества сессий пользователя
+	sessionRetryAfter = 5 * time.Second
+	// rateCleanupInterval - период удаления заполненных корзин
+	rateCleanupInterval = time.Minute
+	// rateVerifiedTTL - время, в течение которого имя и пароль, принятые БД, считаются проверенными
+	rateVerifiedTTL = 10 * time.Minute
+	// rateMaxUserBuckets - максимальное количество корзин непроверенных пользователей с одного адреса
+	rateMaxUserBuckets = 16
+)
+
+func validRateKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "", rateKeyIP, rateKeyUser, rateKeyUserIP:
+		return true
+	}
+	return false
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// ip - адрес клиента, если корзина создана для непроверенного пользователя
+	ip string
+}
+
+// rateLimiter - ограничение частоты запросов алгоритмом token bucket:
+// корзина каждого ключа вмещает burst запросов и пополняется со скоростью rate запросов в секунду
+type rateLimiter struct {
+	sync.Mutex
+	rate        float64
+	burst       float64
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	// verified - время последнего успешного входа по хэшу имени и пароля
+	verified map[[sha256.Size]byte]time.Time
+	// userBuckets - количество корзин непроверенных пользователей по адресу клиента
+	userBuckets map[string]int
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:        rate,
+		burst:       float64(burst),
+		buckets:     make(map[string]*tokenBucket),
+		verified:    make(map[[sha256.Size]byte]time.Time),
+		userBuckets: make(map[string]int),
+	}
+}
+
+func credDigest(user, pass string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.ToUpper(user) + "\x00" + pass))
+}
+
+// verify отмечает имя и пароль как принятые БД
+func (l *rateLimiter) verify(user, pass string, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	l.verified[credDigest(user, pass)] = now
+}
+
+// rateKey возвращает ключ корзины запроса. Имя пользователя из Basic авторизации используется без адреса клиента,
+// только если имя и пароль уже были приняты БД. Иначе в ключ всегда входит адрес клиента, а количество корзин
+// непроверенных пользователей с одного адреса ограничено rateMaxUserBuckets (возвращается адрес для учета)
+func (l *rateLimiter) rateKey(r *http.Request, key string, now time.Time) (string, string) {
+	ip := clientIP(r)
+	user, pass, _ := r.BasicAuth()
+	if user == "" {
+		return ip, ""
+	}
+	t, ok := l.verified[credDigest(user, pass)]
+	verified := ok && now.Sub(t) < rateVerifiedTTL
+	user = strings.ToUpper(user)
+	switch strings.ToLower(key) {
+	case rateKeyUser:
+		if verified {
+			return user, ""
+		}
+	case rateKeyUserIP:
+		if verified {
+			return user + "|" + ip, ""
+		}
+		return user + "|" + ip, ip
+	}
+	return ip, ""
+}
+
+// allowRequest забирает один запрос из корзины, соответствующей запросу r и ключу key (RateLimitKey)
+func (l *rateLimiter) allowRequest(r *http.Request, key string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastCleanup) > rateCleanupInterval {
+		l.cleanup(now)
+	}
+	k, ip := l.rateKey(r, key, now)
+	if _, ok := l.buckets[k]; !ok && ip != "" && l.userBuckets[ip] >= rateMaxUserBuckets {
+		// С адреса перебирают имена пользователей, дальше используется общая корзина адреса
+		k, ip = ip, ""
+	}
+	return l.take(k, ip, now)
+}
+
+// take забирает один запрос из корзины key. Если корзина пуста, возвращает время до ее пополнения.
+// ip задается для корзин непроверенных пользователей
+func (l *rateLimiter) take(key, ip string, now time.Time) (bool, time.Duration) {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now, ip: ip}
+		l.buckets[key] = b
+		if ip != "" {
+			l.userBuckets[ip]++
+		}
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// cleanup удаляет корзины, которые успели заполниться (они ничем не отличаются от новых),
+// и устаревшие отметки о проверке пользователей
+func (l *rateLimiter) cleanup(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+			if b.ip != "" {
+				if l.userBuckets[b.ip]--; l.userBuckets[b.ip] <= 0 {
+					delete(l.userBuckets, b.ip)
+				}
+			}
+		}
+	}
+	for k, t := range l.verified {
+		if now.Sub(t) >= rateVerifiedTTL {
+			delete(l.verified, k)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// newRateLimit ограничивает частоту запросов к обработчику handle
+func newRateLimit(handle httprouter.Handle, limiter *rateLimiter, key, templateBody string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if ok, wait := limiter.allowRequest(r, key, time.Now()); !ok {
+			rateLimitRejections.Add(1)
+			responseTooManyRequests(w, templateBody, wait)
+			return
+		}
+		handle(w, r, p)
+		if info := getRequestInfo(r); info != nil && info.authUser != "" {
+			user, pass, _ := r.BasicAuth()
+			limiter.verify(user, pass, time.Now())
+		}
+	}
+}
+
+// responseTooManyRequests отвечает 429 с заголовком Retry-After.
+// В шаблоне доступно поле RetryAfter - количество секунд до повтора
+func responseTooManyRequests(w http.ResponseWriter, templateBody string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	if templateBody != "" {
+		var buf bytes.Buffer
+		templ, err := template.New(rateLimitTemplate).Parse(templateBody)
+		if err == nil {
+			err = templ.Execute(&buf, struct{ RetryAfter int }{seconds})
+		}
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write(buf.Bytes())
+			return
+		}
+		logError("responseTooManyRequests: ", err.Error())
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprint(w, "Too Many Requests")
+}
@@ -0,0 +1,173 @@
+// ratelimit_test
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+
+	var tests = []struct {
+		key   string
+		after time.Duration
+		ok    bool
+		wait  time.Duration
+	}{
+		{"10.0.0.1", 0, true, 0},
+		{"10.0.0.1", 0, true, 0},
+		{"10.0.0.1", 0, true, 0},
+		{"10.0.0.1", 0, false, 500 * time.Millisecond},
+		{"10.0.0.2", 0, true, 0},
+		{"10.0.0.1", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"10.0.0.1", 250 * time.Millisecond, true, 0},
+		{"10.0.0.1", 0, false, 500 * time.Millisecond},
+	}
+	for k, v := range tests {
+		now = now.Add(v.after)
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		r.RemoteAddr = v.key + ":12345"
+		ok, wait := l.allowRequest(r, rateKeyIP, now)
+		if ok != v.ok || (wait-v.wait) > time.Millisecond || (v.wait-wait) > time.Millisecond {
+			t.Errorf("#%d %s: got %v %v, want %v %v", k, v.key, ok, wait, v.ok, v.wait)
+		}
+	}
+
+	l.cleanup(now.Add(10 * time.Second))
+	if len(l.buckets) != 0 {
+		t.Errorf("Filled buckets should be removed, got %d", len(l.buckets))
+	}
+}
+
+func TestRateKey(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(1, 1)
+	l.verify("u2", "p", now)
+
+	var tests = []struct {
+		key    string
+		user   string
+		pass   string
+		want   string
+		wantIP string
+	}{
+		{"", "u1", "p", "10.0.0.1", ""},
+		{rateKeyIP, "u1", "p", "10.0.0.1", ""},
+		// Имя пользователя без проверки пароля не используется
+		{rateKeyUser, "u1", "p", "10.0.0.1", ""},
+		{rateKeyUser, "u2", "p", "U2", ""},
+		{rateKeyUser, "u2", "wrong", "10.0.0.1", ""},
+		{rateKeyUser, "", "", "10.0.0.1", ""},
+		{rateKeyUserIP, "u1", "p", "U1|10.0.0.1", "10.0.0.1"},
+		{rateKeyUserIP, "u2", "p", "U2|10.0.0.1", ""},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		r.RemoteAddr = "10.0.0.1:12345"
+		if v.user != "" {
+			r.SetBasicAuth(v.user, v.pass)
+		}
+		if got, ip := l.rateKey(r, v.key, now); got != v.want || ip != v.wantIP {
+			t.Errorf("%s %s/%s: got \"%s\" \"%s\", want \"%s\" \"%s\"", v.key, v.user, v.pass, got, ip, v.want, v.wantIP)
+		}
+	}
+}
+
+func TestRateLimitUsers(t *testing.T) {
+	l := newRateLimiter(0.001, 1)
+	handle := newRateLimit(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if _, pass, _ := r.BasicAuth(); pass == "p" {
+			getRequestInfo(r).authUser = "U"
+		}
+		w.Write([]byte("ok"))
+	}, l, rateKeyUser, "")
+	get := func(remoteAddr, user, pass string) int {
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		r.RemoteAddr = remoteAddr
+		r.SetBasicAuth(user, pass)
+		r = withRequestInfo(r, &requestInfo{})
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		return w.Code
+	}
+
+	// Случайные имена пользователей не дают новых корзин
+	if code := get("10.0.0.1:1", "a1", "x"); code != http.StatusOK {
+		t.Errorf("first request: got %d", code)
+	}
+	if code := get("10.0.0.1:2", "a2", "x"); code != http.StatusTooManyRequests {
+		t.Errorf("random user name: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	// После успешного входа пользователь получает свою корзину
+	if code := get("10.0.0.2:1", "u", "p"); code != http.StatusOK {
+		t.Errorf("login: got %d", code)
+	}
+	if code := get("10.0.0.3:1", "u", "p"); code != http.StatusOK {
+		t.Errorf("verified user: got %d", code)
+	}
+	if code := get("10.0.0.5:1", "u", "p"); code != http.StatusTooManyRequests {
+		t.Errorf("verified user from other address should share bucket: got %d", code)
+	}
+	// Чужое имя с неверным паролем не расходует корзину пользователя
+	if code := get("10.0.0.4:1", "u", "x"); code != http.StatusOK {
+		t.Errorf("wrong password: got %d", code)
+	}
+
+	// Количество корзин непроверенных пользователей с одного адреса ограничено
+	l = newRateLimiter(0.001, 1)
+	now := time.Now()
+	for i := 0; i < rateMaxUserBuckets+5; i++ {
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		r.RemoteAddr = "10.0.0.1:1"
+		r.SetBasicAuth(fmt.Sprintf("u%d", i), "x")
+		l.allowRequest(r, rateKeyUserIP, now)
+	}
+	if len(l.buckets) != rateMaxUserBuckets+1 || l.userBuckets["10.0.0.1"] != rateMaxUserBuckets {
+		t.Errorf("Got %d buckets, %d user buckets, want %d and %d", len(l.buckets), l.userBuckets["10.0.0.1"], rateMaxUserBuckets+1, rateMaxUserBuckets)
+	}
+	l.cleanup(now.Add(time.Hour))
+	if len(l.buckets) != 0 || len(l.userBuckets) != 0 {
+		t.Errorf("After cleanup got %d buckets, %d addresses", len(l.buckets), len(l.userBuckets))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	handle := newRateLimit(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.Write([]byte("ok"))
+	}, newRateLimiter(0.5, 1), rateKeyIP, "<p>Retry in {{.RetryAfter}} s</p>")
+
+	var tests = []struct {
+		remoteAddr string
+		code       int
+		body       string
+		retryAfter string
+	}{
+		{"10.0.0.1:1", http.StatusOK, "ok", ""},
+		{"10.0.0.1:2", http.StatusTooManyRequests, "<p>Retry in 2 s</p>", "2"},
+		{"10.0.0.2:1", http.StatusOK, "ok", ""},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		r.RemoteAddr = v.remoteAddr
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		if w.Code != v.code || strings.TrimSpace(w.Body.String()) != v.body || w.Header().Get("Retry-After") != v.retryAfter {
+			t.Errorf("%s: got %d \"%s\" Retry-After \"%s\", want %d \"%s\" \"%s\"",
+				v.remoteAddr, w.Code, w.Body.String(), w.Header().Get("Retry-After"), v.code, v.body, v.retryAfter)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	responseTooManyRequests(w, "", 100*time.Millisecond)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Without template: got %d Retry-After \"%s\"", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/vsdutka/iplsgo/otasker"
@@ -22,20 +23,51 @@ var (
 
 const fmtReaderFileName = "./reader.log"
 
+// Разделитель DSN в -dsn. Соединение устанавливается с первой доступной БД из списка
+const dsnSeparator = ";"
+
+// Максимальный интервал повторного чтения после ошибок
+const maxReadBackoff = 5 * time.Minute
+
+type readerDSN struct {
+	username string
+	password string
+	sid      string
+}
+
 var (
-	stopChan        = make(chan struct{})
-	stoppedChan     = make(chan struct{})
-	conn            *oracle.Connection
-	reader_username string
-	reader_password string
-	reader_sid      string
-	configname      string
-	hostname        string
+	stopChan    = make(chan struct{})
+	stoppedChan = make(chan struct{})
+	conn        *oracle.Connection
+	readerDSNs  []readerDSN
+	configname  string
+	hostname    string
 )
 
 func initReading(dsn, configName string) error {
-	reader_username, reader_password, reader_sid = oracle.SplitDSN(dsn)
+	readerDSNs = nil
+	for _, v := range strings.Split(dsn, dsnSeparator) {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		username, password, sid := oracle.SplitDSN(v)
+		readerDSNs = append(readerDSNs, readerDSN{username, password, sid})
+	}
+	if len(readerDSNs) == 0 {
+		return errgo.New("Oracle DSN is empty")
+	}
 	configname = configName
+	if configCacheName == "" {
+		configCacheName = configName + ".cache"
+	}
+	dsns := make([]string, len(readerDSNs))
+	for k, v := range readerDSNs {
+		dsns[k] = v.username + "@" + v.sid
+	}
+	setReaderState(func(s *readerStatus) {
+		s.DSNs = dsns
+		s.CacheFile = configCacheName
+	})
 	var err error
 	hostname = *hostFlag
 	if hostname == "" {
@@ -43,6 +75,11 @@ func initReading(dsn, configName string) error {
 			return errgo.Newf("Error getting host name: %s\n", err)
 		}
 	}
+	initReaderLog()
+	return nil
+}
+
+func initReaderLog() {
 	//В этот момент нет конфигурации, поэтому создаем файл лога рядом с исполнимым файлом
 	dir, _ := filepath.Split(fmtReaderFileName)
 	os.MkdirAll(dir, os.ModeDir)
@@ -52,25 +89,30 @@ func initReading(dsn, configName string) error {
 		logError(err)
 	}
 	readerLog = log.New(logFile, "", log.LstdFlags|log.Lshortfile)
-	return nil
 }
 
 func startReading(dsn, configName string, timeout time.Duration) error {
+	if err := initReading(dsn, configName); err != nil {
+		return err
+	}
+	return startPolling(readConfigCached, timeout)
+}
 
+// startPolling читает конфигурацию через read, применяет ее и далее перечитывает каждые timeout
+func startPolling(read func() ([]byte, error), timeout time.Duration) error {
 	var (
 		err error
 		buf []byte
 	)
-	if err = initReading(dsn, configName); err != nil {
-		return err
-	}
-
-	if buf, err = readConfig(); err != nil {
+	if buf, err = read(); err != nil {
+		setReaderResult(err)
 		return errgo.Newf("Error read configuration: %s\n", err)
 	}
-	if err = parseConfig(buf); err != nil {
+	if err = applyConfig(buf); err != nil {
+		setReaderResult(err)
 		return errgo.Newf("Error parse configuration: %s\n", err)
 	}
+	setReaderResult(nil)
 	go func(timeout time.Duration) {
 		defer func() {
 			if conn != nil {
@@ -81,13 +123,16 @@ func startReading(dsn, configName string, timeout time.Duration) error {
 		}()
 
 		timer := time.NewTimer(timeout)
+		failures := 0
 
 		for {
 			select {
 			case <-stopChan:
 				{
-					conn.Free(true)
-					conn = nil
+					if conn != nil {
+						conn.Free(true)
+						conn = nil
+					}
 					stoppedChan <- struct{}{}
 					return
 				}
@@ -97,24 +142,27 @@ func startReading(dsn, configName string, timeout time.Duration) error {
 					err := func() error {
 						var buf []byte
 						var err error
-						if buf, err = readConfig(); err != nil {
+						if buf, err = read(); err != nil {
 							return errgo.Newf("Error read configuration: %s\n", err)
 						}
 
-						if err = parseConfig(buf); err != nil {
+						if err = applyConfig(buf); err != nil {
 							return errgo.Newf("Error parse configuration: %s\n", err)
 						}
 						return nil
 					}()
 
 					if err != nil {
+						failures++
 						readerLog.Printf("Service %s - Configuration was read in %6.4f seconds with error. Error: %s\n", confServiceName, time.Since(bg).Seconds(), err)
 					} else {
+						failures = 0
 						readerLog.Printf("Service %s - Configuration was read in %6.4f seconds\n", confServiceName, time.Since(bg).Seconds())
 					}
+					setReaderResult(err)
 					configReadDuration.Set(time.Since(bg).Seconds())
-					// Инициируем следующий тик через timeout
-					timer.Reset(timeout)
+					// Инициируем следующий тик через timeout, после ошибок - реже
+					timer.Reset(readBackoff(timeout, failures))
 
 				}
 			}
@@ -145,14 +193,8 @@ func readConfig() ([]byte, error) {
 		}
 	}
 	if conn == nil {
-		logInfof("Try to login %s@%s\n", reader_username, reader_sid)
-		conn, err = oracle.NewConnection(reader_username, reader_password, reader_sid, false)
-		if err != nil {
+		if err = connectReader(); err != nil {
 			// Выходим. Прочитать не получиться
-			if conn != nil {
-				conn.Free(true)
-			}
-			conn = nil
 			return nil, err
 		}
 	}
@@ -183,4 +225,45 @@ func readConfig() ([]byte, error) {
 	return buf, nil
 }
 
+// connectReader соединяется с первой доступной БД из списка -dsn
+func connectReader() error {
+	errs := make([]string, 0, len(readerDSNs))
+	for k, v := range readerDSNs {
+		logInfof("Try to login %s@%s\n", v.username, v.sid)
+		c, err := oracle.NewConnection(v.username, v.password, v.sid, false)
+		if err != nil {
+			if c != nil {
+				c.Free(true)
+			}
+			readerLog.Printf("Service %s - Error login %s@%s: %s\n", confServiceName, v.username, v.sid, err)
+			errs = append(errs, fmt.Sprintf("%s@%s: %s", v.username, v.sid, err))
+			continue
+		}
+		conn = c
+		if k != 0 {
+			readerLog.Printf("Service %s - Failover to %s@%s\n", confServiceName, v.username, v.sid)
+		}
+		setReaderState(func(s *readerStatus) { s.ActiveDSN = v.username + "@" + v.sid })
+		return nil
+	}
+	setReaderState(func(s *readerStatus) { s.ActiveDSN = "" })
+	return errgo.New(strings.Join(errs, "; "))
+}
+
+// readBackoff удваивает интервал чтения после каждой ошибки подряд, но не более maxReadBackoff
+func readBackoff(timeout time.Duration, failures int) time.Duration {
+	limit := maxReadBackoff
+	if timeout > limit {
+		limit = timeout
+	}
+	d := timeout
+	for i := 0; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
 const stmReadConfig = `select * from table(c.config(:1, :2, ''))`
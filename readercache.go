@@ -0,0 +1,114 @@
+// readercache
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	sourceDB    = "db"
+	sourceFile  = "file"
+	sourceCache = "cache"
+)
+
+type readerStatus struct {
+	Source      string
+	DSNs        []string
+	ActiveDSN   string
+	CacheFile   string
+	LastRead    time.Time
+	LastSuccess time.Time
+	LastError   string
+	Failures    int
+}
+
+var (
+	configCacheName string
+	lastCached      []byte
+
+	readerStateLock sync.Mutex
+	readerState     readerStatus
+)
+
+func setReaderState(f func(s *readerStatus)) {
+	readerStateLock.Lock()
+	defer readerStateLock.Unlock()
+	f(&readerState)
+}
+
+func getReaderState() readerStatus {
+	readerStateLock.Lock()
+	defer readerStateLock.Unlock()
+	return readerState
+}
+
+func setReaderResult(err error) {
+	setReaderState(func(s *readerStatus) {
+		s.LastRead = time.Now()
+		if err != nil {
+			s.LastError = err.Error()
+			s.Failures++
+			return
+		}
+		s.LastSuccess = s.LastRead
+		s.LastError = ""
+		s.Failures = 0
+	})
+}
+
+// readConfigCached читает конфигурацию из БД и сохраняет ее в локальный файл.
+// Если при запуске ни одна БД недоступна, используется конфигурация из этого файла
+func readConfigCached() ([]byte, error) {
+	buf, err := readConfig()
+	if err == nil {
+		setReaderState(func(s *readerStatus) { s.Source = sourceDB })
+		saveConfigCache(buf)
+		return buf, nil
+	}
+	if !getReaderState().LastSuccess.IsZero() {
+		// Конфигурация уже применена, продолжаем работать с ней
+		return nil, err
+	}
+	cached, cacheErr := ioutil.ReadFile(configCacheName)
+	if cacheErr != nil {
+		return nil, err
+	}
+	readerLog.Printf("Service %s - Configuration DB is unreachable, cached configuration \"%s\" is used. Error: %s\n", confServiceName, configCacheName, err)
+	logInfof("Configuration DB is unreachable, cached configuration \"%s\" is used\n", configCacheName)
+	setReaderState(func(s *readerStatus) { s.Source = sourceCache })
+	lastCached = cached
+	return cached, nil
+}
+
+func saveConfigCache(buf []byte) {
+	if configCacheName == "" || bytes.Equal(lastCached, buf) {
+		return
+	}
+	tmpName := configCacheName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, buf, 0600); err != nil {
+		readerLog.Printf("Service %s - Error saving configuration cache: %s\n", confServiceName, err)
+		return
+	}
+	if err := os.Rename(tmpName, configCacheName); err != nil {
+		readerLog.Printf("Service %s - Error saving configuration cache: %s\n", confServiceName, err)
+		return
+	}
+	lastCached = append(lastCached[:0], buf...)
+}
+
+func confReader(w http.ResponseWriter, r *http.Request) {
+	buf, err := json.Marshal(getReaderState())
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
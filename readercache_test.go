@@ -0,0 +1,67 @@
+// readercache_test
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadBackoff(t *testing.T) {
+	var tests = []struct {
+		timeout  time.Duration
+		failures int
+		res      time.Duration
+	}{
+		{10 * time.Second, 0, 10 * time.Second},
+		{10 * time.Second, 1, 20 * time.Second},
+		{10 * time.Second, 3, 80 * time.Second},
+		{10 * time.Second, 100, maxReadBackoff},
+		{10 * time.Minute, 3, 10 * time.Minute},
+	}
+	for _, v := range tests {
+		if res := readBackoff(v.timeout, v.failures); res != v.res {
+			t.Errorf("readBackoff(%v, %d): got %v, want %v", v.timeout, v.failures, res, v.res)
+		}
+	}
+}
+
+func TestReadConfigCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	readerLog = log.New(ioutil.Discard, "", 0)
+	readerDSNs = nil
+	configCacheName = filepath.Join(dir, "conf.cache")
+	defer func() { configCacheName = "" }()
+	setReaderState(func(s *readerStatus) { *s = readerStatus{} })
+
+	if _, err := readConfigCached(); err == nil {
+		t.Fatal("Should be error without cache")
+	}
+
+	saveConfigCache([]byte(`{"Http.Port":1}`))
+	lastCached = nil
+	buf, err := readConfigCached()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != `{"Http.Port":1}` {
+		t.Errorf("got \"%s\"", buf)
+	}
+	if s := getReaderState(); s.Source != sourceCache {
+		t.Errorf("Source should be \"%s\", was \"%s\"", sourceCache, s.Source)
+	}
+
+	// После успешного запуска кеш не используется, ошибка возвращается
+	setReaderResult(nil)
+	if _, err := readConfigCached(); err == nil {
+		t.Fatal("Should be error after start")
+	}
+}
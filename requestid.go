@@ -0,0 +1,45 @@
+// requestid
+package main
+
+import (
+	"net/http"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	requestIDHeader = "X-Request-Id"
+	// Ограничение длины определяется dbms_session.set_identifier
+	maxRequestIDLen = 64
+)
+
+// validRequestID проверяет идентификатор запроса, полученный от клиента
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID возвращает идентификатор из заголовка X-Request-Id или новый, если заголовка нет или он некорректен
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	return uuid.New()
+}
+
+func getRequestID(r *http.Request) string {
+	if info := getRequestInfo(r); info != nil {
+		return info.requestID
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+// requestid_test
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var tests = []struct {
+		header string
+		adopt  bool
+	}{
+		{"", false},
+		{"abc-123_x.y:z", true},
+		{"f47ac10b-58cc-4372-a567-0e02b2c3d479", true},
+		{"bad id", false},
+		{"bad'id", false},
+		{strings.Repeat("a", maxRequestIDLen), true},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+	}
+	for _, v := range tests {
+		var env map[string]string
+		h := &loggedHandler{handlerFunc: func() http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				env = makeEnvParams(r, "", "-", "/")
+				responseError(w, "{{.RequestID}}", "")
+			})
+		}}
+		r := httptest.NewRequest("GET", "/ti8/p", nil)
+		if v.header != "" {
+			r.Header.Set(requestIDHeader, v.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		id := w.Header().Get(requestIDHeader)
+		if v.adopt && id != v.header {
+			t.Errorf("%q: got id \"%s\"", v.header, id)
+		}
+		if !v.adopt && (id == v.header || !validRequestID(id)) {
+			t.Errorf("%q: new id expected, got \"%s\"", v.header, id)
+		}
+		if env["REQUEST_ID"] != id {
+			t.Errorf("%q: CGI REQUEST_ID \"%s\" differs from \"%s\"", v.header, env["REQUEST_ID"], id)
+		}
+		if w.Body.String() != id {
+			t.Errorf("%q: error template got \"%s\", want \"%s\"", v.header, w.Body.String(), id)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// secrets
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+// Ссылки на секреты в конфигурации:
+//
+//	${env:NAME}            - значение переменной окружения
+//	${file:/run/secrets/x} - содержимое файла без завершающего перевода строки
+//	${enc:BASE64}          - значение, зашифрованное AES-GCM ключом из файла -secret_key (см. -encrypt)
+var secretRefRe = regexp.MustCompile(`\$\{(env|file|enc):([^}]*)\}`)
+
+const secretMask = "******"
+
+var (
+	secretKeyFileName string
+
+	secretsLock sync.RWMutex
+	// Значения, полученные по ссылкам. Отсортированы по убыванию длины для корректной маскировки
+	secretValues []string
+)
+
+// resolveSecrets заменяет ссылки на секреты их значениями. Полученные значения добавляются в found
+func resolveSecrets(s string, found map[string]bool) (string, error) {
+	var resErr error
+	res := secretRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if resErr != nil {
+			return ""
+		}
+		m := secretRefRe.FindStringSubmatch(ref)
+		var (
+			val string
+			err error
+		)
+		switch m[1] {
+		case "env":
+			var ok bool
+			if val, ok = os.LookupEnv(m[2]); !ok {
+				err = errgo.Newf("environment variable \"%s\" is not set", m[2])
+			}
+		case "file":
+			var buf []byte
+			if buf, err = ioutil.ReadFile(m[2]); err == nil {
+				val = strings.TrimRight(string(buf), "\r\n")
+			}
+		case "enc":
+			val, err = decryptSecret(m[2])
+		}
+		if err != nil {
+			resErr = errgo.Newf("error resolving secret \"${%s:...}\": %s", m[1], err)
+			return ""
+		}
+		if val != "" {
+			found[val] = true
+		}
+		return val
+	})
+	return res, resErr
+}
+
+// resolveConfigSecrets подставляет секреты в поля конфигурации, в которых допускаются ссылки
+func resolveConfigSecrets(c *serverConfigHolder, found map[string]bool) error {
+	fields := []*string{&c.HTTPSslCert, &c.HTTPSslKey, &c.HTTPClientCA}
+	for k := range c.HTTPCertificates {
+		fields = append(fields, &c.HTTPCertificates[k].Cert, &c.HTTPCertificates[k].Key)
+	}
+	for k := range c.Handlers {
+		h := &c.Handlers[k]
+		fields = append(fields, &h.DefUserName, &h.DefUserPass, &h.SoapUserName, &h.SoapUserPass, &h.SoapConnStr)
+		for i := range h.Grps {
+			fields = append(fields, &h.Grps[i].SID)
+		}
+	}
+	for _, f := range fields {
+		v, err := resolveSecrets(*f, found)
+		if err != nil {
+			return err
+		}
+		*f = v
+	}
+	return nil
+}
+
+func updateSecrets(found map[string]bool) {
+	vals := make([]string, 0, len(found))
+	for v := range found {
+		vals = append(vals, v)
+	}
+	sort.Slice(vals, func(i, j int) bool { return len(vals[i]) > len(vals[j]) })
+
+	secretsLock.Lock()
+	secretValues = vals
+	secretsLock.Unlock()
+}
+
+// maskSecrets заменяет в строке все значения, полученные по ссылкам на секреты
+func maskSecrets(s string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for _, v := range secretValues {
+		s = strings.Replace(s, v, secretMask, -1)
+	}
+	return s
+}
+
+func secretKey() ([]byte, error) {
+	if secretKeyFileName == "" {
+		return nil, errgo.New("secret key file is not set (-secret_key)")
+	}
+	buf, err := ioutil.ReadFile(secretKeyFileName)
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256([]byte(strings.TrimSpace(string(buf))))
+	return key[:], nil
+}
+
+func newSecretCipher() (cipher.AEAD, error) {
+	key, err := secretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret возвращает ссылку ${enc:...} для значения
+func encryptSecret(val string) (string, error) {
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	buf := gcm.Seal(nonce, nonce, []byte(val), nil)
+	return "${enc:" + base64.StdEncoding.EncodeToString(buf) + "}", nil
+}
+
+func decryptSecret(enc string) (string, error) {
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	buf, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < gcm.NonceSize() {
+		return "", errgo.New("encrypted value is too short")
+	}
+	val, err := gcm.Open(nil, buf[:gcm.NonceSize()], buf[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
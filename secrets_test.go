@@ -0,0 +1,74 @@
+// secrets_test
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("from_file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secretKeyFileName = filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(secretKeyFileName, []byte("local key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { secretKeyFileName = "" }()
+	os.Setenv("IPLSGO_TEST_SECRET", "from_env")
+	defer os.Unsetenv("IPLSGO_TEST_SECRET")
+
+	enc, err := encryptSecret("from_enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		src   string
+		res   string
+		isErr bool
+	}{
+		{"plain", "plain", false},
+		{"${app_dir}", "${app_dir}", false},
+		{"${env:IPLSGO_TEST_SECRET}", "from_env", false},
+		{"db/${file:" + secretFile + "}@sid", "db/from_file@sid", false},
+		{enc, "from_enc", false},
+		{"${env:IPLSGO_TEST_ABSENT}", "", true},
+		{"${file:" + filepath.Join(dir, "absent") + "}", "", true},
+		{"${enc:AAAA}", "", true},
+	}
+	for _, v := range tests {
+		found := make(map[string]bool)
+		res, err := resolveSecrets(v.src, found)
+		if (err != nil) != v.isErr {
+			t.Errorf("\"%s\": error %v", v.src, err)
+			continue
+		}
+		if !v.isErr && res != v.res {
+			t.Errorf("\"%s\": got \"%s\", want \"%s\"", v.src, res, v.res)
+		}
+	}
+
+	c := serverConfigHolder{
+		HTTPSslKey: "${env:IPLSGO_TEST_SECRET}",
+		Handlers:   []handlerConfigHolder{{DefUserPass: enc, SoapConnStr: "${file:" + secretFile + "}"}},
+	}
+	found := make(map[string]bool)
+	if err := resolveConfigSecrets(&c, found); err != nil {
+		t.Fatal(err)
+	}
+	updateSecrets(found)
+	defer updateSecrets(nil)
+	if res := maskSecrets("from_env from_enc from_file plain"); res != "****** ****** ****** plain" {
+		t.Errorf("mask: got \"%s\"", res)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -14,9 +15,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -30,7 +33,12 @@ import (
 )
 
 const (
-	defHTTPtimeout = 2400000
+	defHTTPtimeout     = 2400000
+	defShutdownTimeout = 30000
+	// shutdownGrace - дополнительное время, за которое прерванные при остановке запросы должны вернуть ответ
+	shutdownGrace = 15 * time.Second
+	// logFlushTimeout - время ожидания записи лога при остановке
+	logFlushTimeout = 5 * time.Second
 )
 
 var (
@@ -42,111 +50,203 @@ var (
 	confHTTPDebugPort    int
 	confHTTPReadTimeout  int
 	confHTTPWriteTimeout int
+	confShutdownTimeout  = defShutdownTimeout
+	confShutdownDrain    int
 	confHTTPSsl          bool
 	confHTTPSslCert      string
 	confHTTPSslKey       string
+	confHTTPSslCertFile  string
+	confHTTPSslKeyFile   string
+	confHTTPCertificates []certSource
+	confHTTPSslMinVer    string
+	confHTTPSslCiphers   []string
+	confHTTPDisableHTTP2 bool
+	confHTTPClientAuth   string
+	confHTTPClientCA     string
 	confHTTPLogDir       string
+	confHTTPLogFormat    string
+	confHTTPLogExtra     bool
+	confHTTPLogRotation  string
+	confHTTPLogMaxSize   int
+	confHTTPLogCompress  bool
+	confHTTPLogMaxFiles  int
+	confHTTPLogMaxAge    int
+	confHTTPLogNoStdout  bool
+	confHTTPRedactParams []string
+	confHTTPTrustedProxy []string
+	confHTTPDebugAllow   []string
+	confHTTPDebugDeny    []string
+	confHTTPProxyProto   bool
+	confHTTPDebugProxy   bool
+	confHTTPProxyFrom    []string
+	confHTTPReadyReader  = true
+	confTrustedProxies   ipList
+	confProxyFrom        ipList
+	confDebugFilter      *ipFilter
+	confReadyTargets     []readyTarget
 	basePath             string
 	prevConf             []byte
 
-	router *httprouter.Router
+	router *vhostRouter
 )
 
 var connCounter = metrics.NewInt("open_connections", "HTTP - Number of open connections", "", "")
 
-func startServer() {
-	go func() {
-		if confHTTPDebugPort != 0 {
-			logInfof("Debug listener starting on port \"%d\"\n", confHTTPDebugPort)
-			http.HandleFunc("/debug/conf/server", confServer)
-			http.HandleFunc("/debug/conf/users", confUsers)
-			debugHTTP := &http.Server{Addr: fmt.Sprintf(":%d", confHTTPDebugPort),
-				ReadTimeout:  time.Duration(confHTTPReadTimeout) * time.Millisecond,
-				WriteTimeout: time.Duration(confHTTPReadTimeout) * time.Millisecond,
-				Handler: &loggedHandler{func() http.Handler {
-					return http.DefaultServeMux
-				}},
-			}
-			if err := debugHTTP.ListenAndServe(); err != nil {
-				logError(err)
+var (
+	serverStarted bool
+	mainListener  = &httpListener{
+		name: "Main",
+		//Позволяет отслеживать состояние клиентского соединения
+		connState: func(conn net.Conn, cs http.ConnState) {
+			switch cs {
+			case http.StateNew:
+				connCounter.Add(1)
+			case http.StateClosed:
+				connCounter.Add(-1)
 			}
-		}
-	}()
-	if confHTTPSsl {
-		logInfof("Main listener starting on port \"%d\" with SSL support\n", confHTTPPort)
-	} else {
-		logInfof("Main listener starting on port \"%d\"\n", confHTTPPort)
+		},
+		handler: &loggedHandler{func() http.Handler {
+			confLock.RLock()
+			defer confLock.RUnlock()
+			return router
+		}},
+		tlsConfig: &tls.Config{
+			GetCertificate: getCertificate,
+		},
 	}
-	go func() {
-		//		handler := http.NewServeMux()
-		//		handler.HandleFunc("/", serveHTTP)
-		serverHTTP := &http.Server{Addr: fmt.Sprintf(":%d", confHTTPPort),
-			ReadTimeout:  time.Duration(confHTTPReadTimeout) * time.Millisecond,
-			WriteTimeout: time.Duration(confHTTPWriteTimeout) * time.Millisecond,
-			//Позволяет отслеживать состояние клиентского соединения
-			ConnState: func(conn net.Conn, cs http.ConnState) {
-				switch cs {
-				case http.StateNew:
-					connCounter.Add(1)
-				case http.StateClosed:
-					connCounter.Add(-1)
-				}
-			},
-			//Handler: handler,
-			Handler: &loggedHandler{func() http.Handler {
-				confLock.RLock()
-				defer confLock.RUnlock()
-				return router
-			}},
-		}
-
-		if confHTTPSsl {
-			err := func() error {
-				addr := serverHTTP.Addr
-				if addr == "" {
-					addr = ":https"
-				}
-				config := &tls.Config{}
-				if serverHTTP.TLSConfig != nil {
-					*config = *serverHTTP.TLSConfig
-				}
-				if config.NextProtos == nil {
-					config.NextProtos = []string{"HTTP/1.1"}
+	debugListener = &httpListener{
+		name: "Debug",
+		handler: &loggedHandler{func() http.Handler {
+			confLock.RLock()
+			filter := confDebugFilter
+			confLock.RUnlock()
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !filter.allowed(clientIP(r)) {
+					responseForbidden(w)
+					return
 				}
+				r.URL.Path = strings.ToLower(r.URL.Path)
+				http.DefaultServeMux.ServeHTTP(w, r)
+			})
+		}},
+	}
+)
 
-				var err error
-				config.Certificates = make([]tls.Certificate, 1)
-				config.Certificates[0], err = tls.X509KeyPair([]byte(confHTTPSslCert), []byte(confHTTPSslKey))
-				if err != nil {
-					return err
-				}
+func startServer() {
+	confLock.Lock()
+	serverStarted = true
+	confLock.Unlock()
+	applyServerConfig()
+	atomic.StoreInt32(&healthy, 1)
+}
 
-				ln, err := net.Listen("tcp", addr)
-				if err != nil {
-					return err
-				}
+// applyServerConfig применяет к слушателям текущие порты, таймауты и SSL сертификат.
+// Изменения таймаутов действуют для новых соединений, выполняющиеся запросы не прерываются
+func applyServerConfig() {
+	confLock.RLock()
+	started := serverStarted
+	port, debugPort := confHTTPPort, confHTTPDebugPort
+	readTimeout := time.Duration(confHTTPReadTimeout) * time.Millisecond
+	writeTimeout := time.Duration(confHTTPWriteTimeout) * time.Millisecond
+	ssl := confHTTPSsl
+	certs := serverCertSources(confHTTPSslCert, confHTTPSslKey, confHTTPSslCertFile, confHTTPSslKeyFile, confHTTPCertificates)
+	minVersion, ciphers, http2 := confHTTPSslMinVer, confHTTPSslCiphers, !confHTTPDisableHTTP2
+	clientAuthMode, clientCA := confHTTPClientAuth, confHTTPClientCA
+	var mainProxyFrom, debugProxyFrom ipList
+	if confHTTPProxyProto {
+		mainProxyFrom = confProxyFrom
+	}
+	if confHTTPDebugProxy {
+		debugProxyFrom = confProxyFrom
+	}
+	confLock.RUnlock()
 
-				tlsListener := tls.NewListener(ln, config)
-				return serverHTTP.Serve(tlsListener)
-			}()
+	if !started {
+		return
+	}
 
-			if err != nil {
-				logError(err)
-			}
-		} else {
-			if err := serverHTTP.ListenAndServe(); err != nil {
-				logError(err)
+	mainListener.setProxyProtocol(mainProxyFrom)
+	debugListener.setProxyProtocol(debugProxyFrom)
+
+	if err := debugListener.apply(debugPort, false, readTimeout, readTimeout); err != nil {
+		logError(err)
+	}
+	if ssl {
+		if err := setTLSOptions(minVersion, ciphers, http2); err != nil {
+			logError(err)
+		}
+		if err := setCertificates(certs); err != nil {
+			logError(err)
+			if _, err := getCertificate(nil); err != nil {
+				// Без сертификата SSL соединения обслуживать невозможно
+				return
 			}
 		}
+		if err := setClientAuth(clientAuthMode, clientCA); err != nil {
+			logError(err)
+		}
+	}
+	if err := mainListener.apply(port, ssl, readTimeout, writeTimeout); err != nil {
+		logError(err)
+	}
+}
 
+// stopServer останавливает прием соединений и дожидается завершения выполняющихся запросов.
+// Запросы к Oracle, не завершившиеся за Http.ShutdownTimeout, прерываются, свободные сессии закрываются
+func stopServer() {
+	// Балансировщик должен перестать направлять запросы до закрытия слушателя: в течение
+	// Http.ShutdownDrainDelay /readyz возвращает ошибку, а запросы продолжают обслуживаться
+	atomic.StoreInt32(&healthy, 0)
+
+	confLock.Lock()
+	serverStarted = false
+	timeout := time.Duration(confShutdownTimeout) * time.Millisecond
+	drain := time.Duration(confShutdownDrain) * time.Millisecond
+	confLock.Unlock()
+
+	if drain > 0 {
+		logInfof("Waiting %v for load balancer to stop sending requests\n", drain)
+		time.Sleep(drain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+shutdownGrace)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := mainListener.shutdown(ctx); err != nil {
+			logError(err)
+		}
 	}()
+	if broken := otasker.Shutdown(timeout); broken > 0 {
+		logInfof("%d running session(s) were interrupted\n", broken)
+	}
+	wg.Wait()
 
-}
-func stopServer() {
-	//	s.configReader.shutdown()
+	if err := debugListener.shutdown(ctx); err != nil {
+		logError(err)
+	}
+	flushLog(logFlushTimeout)
 }
 
 func init() {
+	mainListener.tlsConfig.GetConfigForClient = tlsConfigForClient(mainListener.tlsConfig)
+	// Пробы не попадают в лог запросов
+	mainListener.handler = withHealth(mainListener.handler)
+
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", debugReadyz)
+	http.HandleFunc("/metrics", promMetrics)
+	http.HandleFunc("/debug/conf/server", confServer)
+	http.HandleFunc("/debug/conf/users", confUsers)
+	http.HandleFunc("/debug/conf/check", confCheck)
+	http.HandleFunc("/debug/conf/reader", confReader)
+	http.HandleFunc("/debug/conf/history", confHistoryList)
+	http.HandleFunc("/debug/conf/history/diff", confHistoryDiff)
+	http.HandleFunc("/debug/conf/history/rollback", confHistoryRollback)
+
 	exeName, err := osext.Executable()
 
 	if err == nil {
@@ -157,52 +257,6 @@ func init() {
 	}
 }
 
-func serveHTTP(w http.ResponseWriter, r *http.Request) {
-	rt := func() *httprouter.Router {
-		confLock.RLock()
-		defer confLock.RUnlock()
-		return router
-	}()
-	r.URL.Path = strings.ToLower(r.URL.Path)
-
-	countOfRequests.Add(1)
-	defer countOfRequests.Add(-1)
-
-	start := time.Now()
-	writer := statusWriter{w, 0, 0}
-	rt.ServeHTTP(&writer, r)
-	end := time.Now()
-	latency := end.Sub(start)
-	statusCode := writer.status
-	length := writer.length
-	user, _, ok := r.BasicAuth()
-	if !ok {
-		user = "-"
-	}
-	url := r.URL.Path
-
-	params := r.Form.Encode()
-	if params != "" {
-		url = url + "?" + params
-	}
-
-	writeToLog(fmt.Sprintf("%s, %20s, %s, %s, %12d, %12d, %8d, %d, %s, %s, %v\r\n",
-		r.RemoteAddr,
-		user,
-		end.Format("2006.01.02"),
-		end.Format("15:04:05.000000000"),
-		//r.Proto,
-		//r.Host,
-		length,
-		r.ContentLength,
-		time.Since(start)/time.Millisecond,
-		statusCode,
-		r.Method,
-		url,
-		latency,
-	))
-}
-
 func resetConfig() {
 	confLock.Lock()
 	defer confLock.Unlock()
@@ -213,15 +267,46 @@ func resetConfig() {
 	confHTTPDebugPort = 0
 	confHTTPReadTimeout = defHTTPtimeout
 	confHTTPWriteTimeout = defHTTPtimeout
+	confShutdownTimeout = defShutdownTimeout
+	confShutdownDrain = 0
 	confHTTPSsl = false
 	confHTTPSslCert = ""
 	confHTTPSslKey = ""
+	confHTTPSslCertFile = ""
+	confHTTPSslKeyFile = ""
+	confHTTPCertificates = nil
+	confHTTPSslMinVer = ""
+	confHTTPSslCiphers = nil
+	confHTTPDisableHTTP2 = false
+	confHTTPClientAuth = ""
+	confHTTPClientCA = ""
 	confHTTPLogDir = ""
+	confHTTPLogFormat = ""
+	confHTTPLogExtra = false
+	confHTTPLogRotation = ""
+	confHTTPLogMaxSize = 0
+	confHTTPLogCompress = false
+	confHTTPLogMaxFiles = 0
+	confHTTPLogMaxAge = 0
+	confHTTPLogNoStdout = false
+	confHTTPRedactParams = nil
+	otasker.SetRedactedParams(nil)
+	confHTTPTrustedProxy = nil
+	confHTTPDebugAllow = nil
+	confHTTPDebugDeny = nil
+	confHTTPProxyProto = false
+	confHTTPDebugProxy = false
+	confHTTPProxyFrom = nil
+	confHTTPReadyReader = true
+	confTrustedProxies = nil
+	confProxyFrom = nil
+	confDebugFilter = nil
+	confReadyTargets = nil
 	confServerReaded = false
 	// -- //
 	updateUsers(nil)
 	// -- //
-	router = httprouter.New()
+	router = newVhostRouter()
 	prevConf = []byte{}
 }
 func parseConfig(buf []byte) error {
@@ -232,77 +317,184 @@ func parseConfig(buf []byte) error {
 	var c = serverConfigHolder{
 		HTTPReadTimeout:  defHTTPtimeout,
 		HTTPWriteTimeout: defHTTPtimeout,
+		ShutdownTimeout:  defShutdownTimeout,
 		HTTPLogDir:       "${app_dir}\\log\\",
+		HTTPReadyReader:  true,
 	}
 
 	err := json.Unmarshal(buf, &c)
 	if err != nil {
 		return errgo.Newf("error parsing configuration: %s", err)
 	}
+	secrets := make(map[string]bool)
+	if err = resolveConfigSecrets(&c, secrets); err != nil {
+		return errgo.Newf("error parsing configuration: %s", err)
+	}
 
-	func() {
-		newRouter := httprouter.New()
+	trustedProxies, err := parseIPList(c.HTTPTrustedProxy)
+	if err != nil {
+		return errgo.Newf("error parsing configuration: Http.TrustedProxies: %s", err)
+	}
+	debugFilter, err := newIPFilter(c.HTTPDebugAllow, c.HTTPDebugDeny)
+	if err != nil {
+		return errgo.Newf("error parsing configuration: Http.DebugAllowIPs: %s", err)
+	}
+	proxyFrom, err := parseIPList(c.HTTPProxyFrom)
+	if err != nil {
+		return errgo.Newf("error parsing configuration: Http.ProxyProtocolFrom: %s", err)
+	}
+	if err = otasker.CheckRedactPatterns(c.HTTPRedactParams); err != nil {
+		return errgo.Newf("error parsing configuration: Http.RedactParams: %s", err)
+	}
 
-		for k := range c.Handlers {
-			if c.Handlers[k].Path == "" {
-				continue
-			}
+	newRouter := newVhostRouter()
+	readyTargets := make([]readyTarget, 0)
+
+	for k := range c.Handlers {
+		if c.Handlers[k].Path == "" {
+			continue
+		}
+		readyTargets = append(readyTargets, handlerReadyTargets(&c.Handlers[k])...)
 
-			upath := strings.ToLower(c.Handlers[k].Path)
+		upath := casePath(c.Handlers[k].Path, c.Handlers[k].PathCase)
 
-			switch c.Handlers[k].Type {
-			case "Redirect":
-				{
-					newRouter.GET(upath, newRedirect(c.Handlers[k].RedirectPath))
+		var handle httprouter.Handle
+		switch c.Handlers[k].Type {
+		case "Redirect":
+			{
+				handle = newRedirect(c.Handlers[k].RedirectPath)
+			}
+		case "Static":
+			{
+				handle = newStatic(http.Dir(c.Handlers[k].RootDir), staticOptions{
+					IndexFile:      c.Handlers[k].StaticIndexFile,
+					DisableListing: c.Handlers[k].StaticDisableListing,
+					SPAFallback:    c.Handlers[k].StaticSPAFallback,
+					Precompressed:  c.Handlers[k].StaticPrecompressed,
+					CacheRules:     c.Handlers[k].StaticCacheRules,
+				})
+			}
+		case "owa_apex", "owa_classic", "owa_ekb":
+			{
+				var typeTasker int
+				switch c.Handlers[k].Type {
+				case "owa_apex":
+					typeTasker = otasker.ApexTasker
+				case "owa_classic":
+					typeTasker = otasker.ClassicTasker
+				case "owa_ekb":
+					typeTasker = otasker.EkbTasker
+				}
 
+				templates := map[string]string{}
+				for _, v1 := range c.Handlers[k].Templates {
+					templates[v1.Code] = v1.Body
 				}
-			case "Static":
-				{
-					newRouter.ServeFiles(upath+"/*filepath", http.Dir(c.Handlers[k].RootDir))
+				grps := map[int32]string{}
+				adminFilter, err := newIPFilter(c.Handlers[k].AdminAllowIPs, c.Handlers[k].AdminDenyIPs)
+				if err != nil {
+					return errgo.Newf("error registering handler \"%s\": %s", c.Handlers[k].Path, err)
 				}
-			case "owa_apex", "owa_classic", "owa_ekb":
-				{
-					var typeTasker int
-					switch c.Handlers[k].Type {
-					case "owa_apex":
-						typeTasker = otasker.ApexTasker
-					case "owa_classic":
-						typeTasker = otasker.ClassicTasker
-					case "owa_ekb":
-						typeTasker = otasker.EkbTasker
-					}
 
-					templates := map[string]string{}
-					for _, v1 := range c.Handlers[k].Templates {
-						templates[v1.Code] = v1.Body
-					}
-					grps := map[int32]string{}
+				for _, v1 := range c.Handlers[k].Grps {
+					grps[v1.ID] = v1.SID
+				}
 
-					for _, v1 := range c.Handlers[k].Grps {
-						grps[v1.ID] = v1.SID
-					}
+				handle = newOwa(upath, typeTasker,
+					time.Duration(c.Handlers[k].SessionIdleTimeout)*time.Millisecond,
+					time.Duration(c.Handlers[k].SessionWaitTimeout)*time.Millisecond,
+					c.Handlers[k].RequestUserInfo, c.Handlers[k].RequestUserRealm,
+					c.Handlers[k].DefUserName, c.Handlers[k].DefUserPass,
+					c.Handlers[k].BeforeScript, c.Handlers[k].AfterScript,
+					c.Handlers[k].ParamStoreProc, c.Handlers[k].DocumentTable,
+					c.Handlers[k].ClientCertUser, c.Handlers[k].MaxUserSessions,
+					adminFilter, templates, grps)
+			}
 
-					f := newOwa(upath, typeTasker,
-						time.Duration(c.Handlers[k].SessionIdleTimeout)*time.Millisecond,
-						time.Duration(c.Handlers[k].SessionWaitTimeout)*time.Millisecond,
-						c.Handlers[k].RequestUserInfo, c.Handlers[k].RequestUserRealm,
-						c.Handlers[k].DefUserName, c.Handlers[k].DefUserPass,
-						c.Handlers[k].BeforeScript, c.Handlers[k].AfterScript,
-						c.Handlers[k].ParamStoreProc, c.Handlers[k].DocumentTable,
-						templates, grps)
-
-					newRouter.GET(upath+"/*proc", f)
-					newRouter.POST(upath+"/*proc", f)
+		case "SOAP":
+			{
+				handle = newSoap(upath, c.Handlers[k].SoapUserName, c.Handlers[k].SoapUserPass, c.Handlers[k].SoapConnStr)
+			}
+		case "Proxy":
+			{
+				upstreams, err := parseUpstreams(c.Handlers[k].ProxyUpstreams)
+				if err != nil {
+					return errgo.Newf("error registering handler \"%s\": %s", c.Handlers[k].Path, err)
 				}
+				handle = newProxy(upath, upstreams,
+					c.Handlers[k].ProxyStripPath,
+					c.Handlers[k].ProxyPreserveHost,
+					c.Handlers[k].ProxyPathPrefix,
+					c.Handlers[k].ProxyRequestHeaders,
+					c.Handlers[k].ProxyResponseHeaders,
+					time.Duration(c.Handlers[k].ProxyTimeout)*time.Millisecond,
+					time.Duration(c.Handlers[k].ProxyConnectTimeout)*time.Millisecond,
+				)
+			}
 
-			case "SOAP":
-				{
-					newRouter.GET(upath+"/*proc", newSoap(upath, c.Handlers[k].SoapUserName, c.Handlers[k].SoapUserPass, c.Handlers[k].SoapConnStr))
-					newRouter.POST(upath+"/*proc", newSoap(upath, c.Handlers[k].SoapUserName, c.Handlers[k].SoapUserPass, c.Handlers[k].SoapConnStr))
+		}
+		if handle == nil {
+			continue
+		}
+		if !validCompressLevel(c.Handlers[k].CompressionLevel) {
+			return errgo.Newf("error registering handler \"%s\": compression level %d is out of range 0..9",
+				c.Handlers[k].Path, c.Handlers[k].CompressionLevel)
+		}
+		if c.Handlers[k].CompressionMinSize < 0 {
+			return errgo.Newf("error registering handler \"%s\": negative compression min size %d",
+				c.Handlers[k].Path, c.Handlers[k].CompressionMinSize)
+		}
+		if c.Handlers[k].Compression && compressibleHandler(c.Handlers[k].Type) {
+			handle = newCompress(handle, compressOptions{
+				Level:   c.Handlers[k].CompressionLevel,
+				MinSize: c.Handlers[k].CompressionMinSize,
+			})
+		}
+		if c.Handlers[k].RateLimit > 0 {
+			templateBody := ""
+			for _, v1 := range c.Handlers[k].Templates {
+				if v1.Code == rateLimitTemplate {
+					templateBody = v1.Body
 				}
-
+			}
+			handle = newRateLimit(handle, newRateLimiter(c.Handlers[k].RateLimit, c.Handlers[k].RateBurst),
+				c.Handlers[k].RateLimitKey, templateBody)
+		}
+		if len(c.Handlers[k].ResponseHeaders) != 0 && c.Handlers[k].Type != "Proxy" {
+			handle = newResponseHeaders(handle, c.Handlers[k].ResponseHeaders)
+		}
+		routePath, methods := handlerRoute(c.Handlers[k].Type, upath)
+		if c.Handlers[k].CORS != nil {
+			if err := checkCORSOptions(c.Handlers[k].CORS); err != nil {
+				return errgo.Newf("error registering handler \"%s\": CORS: %s", c.Handlers[k].Path, err)
+			}
+			handle = newCORS(handle, c.Handlers[k].CORS, methods)
+			if !containsFold(methods, "OPTIONS") {
+				methods = append(methods, "OPTIONS")
+			}
+		}
+		// Фильтр адресов применяется первым, в том числе к предварительным запросам CORS
+		filter, err := newIPFilter(c.Handlers[k].AllowIPs, c.Handlers[k].DenyIPs)
+		if err != nil {
+			return errgo.Newf("error registering handler \"%s\": %s", c.Handlers[k].Path, err)
+		}
+		if filter != nil {
+			handle = newIPRestriction(handle, filter)
+		}
+		handle = newInstrumented(handle, normalizeHost(c.Handlers[k].Host), c.Handlers[k].Path, c.Handlers[k].Type)
+		// Proxy всегда передает upstream исходный путь запроса: backend может различать регистр
+		if c.Handlers[k].PathCase == pathCaseInsensitive || c.Handlers[k].Type == "Proxy" {
+			handle = preservePathCase(handle)
+		}
+		for _, method := range methods {
+			if err := addRoute(newRouter.routerFor(c.Handlers[k].Host, c.Handlers[k].PathCase), method, routePath, handle); err != nil {
+				return errgo.Newf("error registering handler \"%s\": %s", c.Handlers[k].Path, err)
 			}
 		}
+	}
+
+	var serverChanged, logChanged bool
+	func() {
 		//		newRouter.GET("/debug/conf/server", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		//			c := serverConfigHolder{
 		//				ServiceName:      confServiceName,
@@ -343,23 +535,87 @@ func parseConfig(buf []byte) error {
 		if !confServerReaded {
 			confServiceName = fmt.Sprintf("%s_%d", c.ServiceName, c.HTTPPort)
 			confServiceDispName = c.ServiceDispName
-			confHTTPPort = c.HTTPPort
-			confHTTPDebugPort = c.HTTPDebugPort
-			confHTTPReadTimeout = c.HTTPReadTimeout
-			confHTTPWriteTimeout = c.HTTPWriteTimeout
-			confHTTPSsl = c.HTTPSsl
-			confHTTPSslCert = c.HTTPSslCert
-			confHTTPSslKey = c.HTTPSslKey
-			confHTTPLogDir = c.HTTPLogDir
-			confServerReaded = true
+		} else {
+			serverChanged = (confHTTPPort != c.HTTPPort) ||
+				(confHTTPDebugPort != c.HTTPDebugPort) ||
+				(confHTTPReadTimeout != c.HTTPReadTimeout) ||
+				(confHTTPWriteTimeout != c.HTTPWriteTimeout) ||
+				(confHTTPSsl != c.HTTPSsl) ||
+				(confHTTPSslCert != c.HTTPSslCert) ||
+				(confHTTPSslKey != c.HTTPSslKey) ||
+				(confHTTPSslCertFile != c.HTTPSslCertFile) ||
+				(confHTTPSslKeyFile != c.HTTPSslKeyFile) ||
+				!reflect.DeepEqual(confHTTPCertificates, c.HTTPCertificates) ||
+				(confHTTPSslMinVer != c.HTTPSslMinVer) ||
+				!reflect.DeepEqual(confHTTPSslCiphers, c.HTTPSslCiphers) ||
+				(confHTTPDisableHTTP2 != c.HTTPDisableHTTP2) ||
+				(confHTTPClientAuth != c.HTTPClientAuth) ||
+				(confHTTPClientCA != c.HTTPClientCA) ||
+				(confHTTPProxyProto != c.HTTPProxyProto) ||
+				(confHTTPDebugProxy != c.HTTPDebugProxy) ||
+				!reflect.DeepEqual(confHTTPProxyFrom, c.HTTPProxyFrom)
+			logChanged = (confHTTPLogDir != c.HTTPLogDir) ||
+				(confHTTPLogRotation != c.HTTPLogRotation) ||
+				(confHTTPLogMaxSize != c.HTTPLogMaxSize) ||
+				(confHTTPLogCompress != c.HTTPLogCompress) ||
+				(confHTTPLogMaxFiles != c.HTTPLogMaxFiles) ||
+				(confHTTPLogMaxAge != c.HTTPLogMaxAge) ||
+				(confHTTPLogNoStdout != c.HTTPLogNoStdout)
 		}
+		confHTTPPort = c.HTTPPort
+		confHTTPDebugPort = c.HTTPDebugPort
+		confHTTPReadTimeout = c.HTTPReadTimeout
+		confHTTPWriteTimeout = c.HTTPWriteTimeout
+		confShutdownTimeout = c.ShutdownTimeout
+		confShutdownDrain = c.ShutdownDrain
+		confHTTPSsl = c.HTTPSsl
+		confHTTPSslCert = c.HTTPSslCert
+		confHTTPSslKey = c.HTTPSslKey
+		confHTTPSslCertFile = c.HTTPSslCertFile
+		confHTTPSslKeyFile = c.HTTPSslKeyFile
+		confHTTPCertificates = c.HTTPCertificates
+		confHTTPSslMinVer = c.HTTPSslMinVer
+		confHTTPSslCiphers = c.HTTPSslCiphers
+		confHTTPDisableHTTP2 = c.HTTPDisableHTTP2
+		confHTTPClientAuth = c.HTTPClientAuth
+		confHTTPClientCA = c.HTTPClientCA
+		confHTTPLogDir = c.HTTPLogDir
+		confHTTPLogFormat = c.HTTPLogFormat
+		confHTTPLogExtra = c.HTTPLogExtra
+		confHTTPLogRotation = c.HTTPLogRotation
+		confHTTPLogMaxSize = c.HTTPLogMaxSize
+		confHTTPLogCompress = c.HTTPLogCompress
+		confHTTPLogMaxFiles = c.HTTPLogMaxFiles
+		confHTTPLogMaxAge = c.HTTPLogMaxAge
+		confHTTPLogNoStdout = c.HTTPLogNoStdout
+		confHTTPRedactParams = c.HTTPRedactParams
+		otasker.SetRedactedParams(c.HTTPRedactParams)
+		confHTTPTrustedProxy = c.HTTPTrustedProxy
+		confHTTPDebugAllow = c.HTTPDebugAllow
+		confHTTPDebugDeny = c.HTTPDebugDeny
+		confHTTPProxyProto = c.HTTPProxyProto
+		confHTTPDebugProxy = c.HTTPDebugProxy
+		confHTTPProxyFrom = c.HTTPProxyFrom
+		confHTTPReadyReader = c.HTTPReadyReader
+		confTrustedProxies = trustedProxies
+		confProxyFrom = proxyFrom
+		confDebugFilter = debugFilter
+		confReadyTargets = readyTargets
+		confServerReaded = true
 		// -- //
 		updateUsers(c.HTTPUsers)
+		updateSecrets(secrets)
 		// -- //
 		router = newRouter
 		// -- //
-		copy(prevConf, buf)
+		prevConf = append(prevConf[:0], buf...)
 	}()
+	if serverChanged {
+		applyServerConfig()
+	}
+	if logChanged {
+		reopenLog()
+	}
 	return nil
 }
 
@@ -371,10 +627,44 @@ func confServer(w http.ResponseWriter, r *http.Request) {
 		HTTPDebugPort:    confHTTPDebugPort,
 		HTTPReadTimeout:  confHTTPReadTimeout,
 		HTTPWriteTimeout: confHTTPWriteTimeout,
+		ShutdownTimeout:  confShutdownTimeout,
+		ShutdownDrain:    confShutdownDrain,
 		HTTPSsl:          confHTTPSsl,
-		HTTPSslCert:      confHTTPSslCert,
-		HTTPSslKey:       confHTTPSslKey,
+		HTTPSslCert:      maskSecrets(confHTTPSslCert),
+		HTTPClientAuth:   confHTTPClientAuth,
+		HTTPClientCA:     maskSecrets(confHTTPClientCA),
 		HTTPLogDir:       confHTTPLogDir,
+		HTTPLogFormat:    confHTTPLogFormat,
+		HTTPLogExtra:     confHTTPLogExtra,
+		HTTPLogRotation:  confHTTPLogRotation,
+		HTTPLogMaxSize:   confHTTPLogMaxSize,
+		HTTPLogCompress:  confHTTPLogCompress,
+		HTTPLogMaxFiles:  confHTTPLogMaxFiles,
+		HTTPLogMaxAge:    confHTTPLogMaxAge,
+		HTTPLogNoStdout:  confHTTPLogNoStdout,
+		HTTPRedactParams: confHTTPRedactParams,
+	}
+	if confHTTPSslKey != "" {
+		c.HTTPSslKey = secretMask
+	}
+	c.HTTPSslCertFile = confHTTPSslCertFile
+	c.HTTPSslKeyFile = confHTTPSslKeyFile
+	c.HTTPSslMinVer = confHTTPSslMinVer
+	c.HTTPSslCiphers = confHTTPSslCiphers
+	c.HTTPDisableHTTP2 = confHTTPDisableHTTP2
+	c.HTTPTrustedProxy = confHTTPTrustedProxy
+	c.HTTPDebugAllow = confHTTPDebugAllow
+	c.HTTPDebugDeny = confHTTPDebugDeny
+	c.HTTPProxyProto = confHTTPProxyProto
+	c.HTTPDebugProxy = confHTTPDebugProxy
+	c.HTTPProxyFrom = confHTTPProxyFrom
+	c.HTTPReadyReader = confHTTPReadyReader
+	for _, v := range confHTTPCertificates {
+		v.Cert = maskSecrets(v.Cert)
+		if v.Key != "" {
+			v.Key = secretMask
+		}
+		c.HTTPCertificates = append(c.HTTPCertificates, v)
 	}
 	buf, err := json.Marshal(c)
 	if err != nil {
@@ -402,10 +692,37 @@ func newRedirect(redirectPath string) func(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// handlerRoute возвращает шаблон пути и HTTP методы, под которыми в роутере регистрируется обработчик данного типа
+func handlerRoute(handlerType, upath string) (string, []string) {
+	switch handlerType {
+	case "Redirect":
+		return upath, []string{"GET"}
+	case "Static":
+		return upath + "/*filepath", []string{"GET"}
+	case "owa_apex", "owa_classic", "owa_ekb", "SOAP":
+		return upath + "/*proc", []string{"GET", "POST"}
+	case "Proxy":
+		return upath + "/*path", []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+	return "", nil
+}
+
+// addRoute регистрирует обработчик в роутере.
+// httprouter паникует при дублирующихся или конфликтующих путях, поэтому панику превращаем в ошибку
+func addRoute(rt *httprouter.Router, method, routePath string, handle httprouter.Handle) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errgo.Newf("%v", r)
+		}
+	}()
+	rt.Handle(method, routePath, handle)
+	return nil
+}
+
 func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeout time.Duration, requestUserInfo bool,
 	requestUserRealm, defUserName, defUserPass, beforeScript,
-	afterScript, paramStoreProc, documentTable string,
-	templates map[string]string, grps map[int32]string,
+	afterScript, paramStoreProc, documentTable, clientCertField string, maxUserSessions int,
+	adminFilter *ipFilter, templates map[string]string, grps map[int32]string,
 ) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -416,12 +733,26 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 
 		vpath := pathStr
 
+		if (procName == "!" || strings.EqualFold(procName, "break_session")) && !adminFilter.allowed(clientIP(r)) {
+			responseForbidden(w)
+			return
+		}
+
 		reqFiles, _ := mltpart.ParseMultipartFormEx(r, 64<<20)
 
 		if procName == "!" {
 			sortKeyName := r.FormValue("Sort")
-			responseTemplate(w, "sessions", sessions, struct{ Sessions otasker.OracleTaskersStats }{
-				otasker.Collect(vpath, sortKeyName, false)})
+			stats := otasker.Collect(vpath, sortKeyName, false)
+			for i := range stats {
+				stats[i].Database = maskSecrets(stats[i].Database)
+				stats[i].UserName = maskSecrets(stats[i].UserName)
+				stats[i].Password = maskSecrets(stats[i].Password)
+				for k, v := range stats[i].LastSteps {
+					v.Statement = maskSecrets(v.Statement)
+					stats[i].LastSteps[k] = v
+				}
+			}
+			responseTemplate(w, "sessions", sessions, struct{ Sessions otasker.OracleTaskersStats }{stats})
 			return
 		}
 		// -- //
@@ -432,7 +763,12 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 			remoteUser = "-"
 		}
 
-		if !requestUserInfo {
+		certUser := clientCertUser(r, clientCertField)
+		if certUser != "" {
+			// Пользователь определен по клиентскому сертификату, Basic авторизация не требуется
+			remoteUser = certUser
+			userName = certUser
+		} else if !requestUserInfo {
 			// Авторизация от клиента не требуется.
 			// Используем значения по умолчанию
 			userName = defUserName
@@ -474,6 +810,12 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 			return
 		}
 
+		if certUser != "" {
+			// Пароля пользователя нет, подключаемся через proxy пользователя: proxy[user]/proxy_password
+			userName = fmt.Sprintf("%s[%s]", defUserName, certUser)
+			userPass = defUserPass
+		}
+
 		sessionID := makeHandlerID(isSpecial, userName, userPass, r.Header.Get("DebugIP"), r)
 		taskID := makeTaskID(r)
 
@@ -481,7 +823,7 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 
 		procParams := r.Form
 
-		if procName == "break_session" {
+		if strings.EqualFold(procName, "break_session") {
 			//FIXME
 			if err := otasker.Break(vpath, sessionID); err != nil {
 				responseError(w, templates["error"], err.Error())
@@ -491,6 +833,15 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 			return
 		}
 
+		if maxUserSessions > 0 {
+			// Новая сессия не создается, если у пользователя их уже maxUserSessions
+			if n, exists := otasker.Sessions(vpath, userName+"|", sessionID); !exists && n >= maxUserSessions {
+				sessionLimitRejections.Add(1)
+				responseTooManyRequests(w, templates[rateLimitTemplate], sessionRetryAfter)
+				return
+			}
+		}
+
 		if sessionWaitTimeout < 0 {
 			sessionWaitTimeout = math.MaxInt64
 		}
@@ -499,10 +850,19 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 			sessionIdleTimeout = math.MaxInt64
 		}
 
+		info := getRequestInfo(r)
+		if info != nil {
+			info.sessionID = sessionDigest(sessionID)
+			info.procName = procName
+		}
+		dbStart := time.Now()
 		res := otasker.Run(vpath, typeTasker, sessionID, taskID, userName, userPass, connStr,
 			paramStoreProc, beforeScript, afterScript, documentTable,
 			cgiEnv, procName, procParams, reqFiles,
 			sessionWaitTimeout, sessionIdleTimeout, dumpFileName)
+		if info != nil {
+			info.dbDuration = time.Since(dbStart)
+		}
 
 		switch res.StatusCode {
 		case otasker.StatusErrorPage:
@@ -553,6 +913,10 @@ func newOwa(pathStr string, typeTasker int, sessionIdleTimeout, sessionWaitTimeo
 			}
 		default:
 			{
+				if info != nil && ok && requestUserInfo && certUser == "" {
+					// Процедура выполнена, значит БД приняла имя и пароль из Basic авторизации
+					info.authUser = userName
+				}
 				location := ""
 				for headerName, headerValues := range res.Headers {
 					for _, headerValue := range headerValues {
@@ -614,44 +978,110 @@ func expandFileName(fileName string) string {
 }
 
 type serverConfigHolder struct {
-	ServiceName      string          `json:"Service.Name"`
-	ServiceDispName  string          `json:"Service.DisplayName"`
-	HTTPPort         int             `json:"Http.Port"`
-	HTTPDebugPort    int             `json:"Http.DebugPort"`
-	HTTPReadTimeout  int             `json:"Http.ReadTimeout"`
-	HTTPWriteTimeout int             `json:"Http.WriteTimeout"`
-	HTTPSsl          bool            `json:"Http.SSL"`
-	HTTPSslCert      string          `json:"Http.SSLCert"`
-	HTTPSslKey       string          `json:"Http.SSLKey"`
-	HTTPLogDir       string          `json:"Http.LogDir"`
-	HTTPUsers        json.RawMessage `json:"Http.Users"`
-	Handlers         []struct {
-		Path               string `json:"Path"`
-		Type               string `json:"Type"`
-		RootDir            string `json:"RootDir"`
-		RedirectPath       string `json:"RedirectPath"`
-		SessionIdleTimeout int    `json:"owa.SessionIdleTimeout"`
-		SessionWaitTimeout int    `json:"owa.SessionWaitTimeout"`
-		RequestUserInfo    bool   `json:"owa.ReqUserInfo"`
-		RequestUserRealm   string `json:"owa.ReqUserRealm"`
-		DefUserName        string `json:"owa.DBUserName"`
-		DefUserPass        string `json:"owa.DBUserPass"`
-		BeforeScript       string `json:"owa.BeforeScript"`
-		AfterScript        string `json:"owa.AfterScript"`
-		ParamStoreProc     string `json:"owa.ParamStroreProc"`
-		DocumentTable      string `json:"owa.DocumentTable"`
-		Templates          []struct {
-			Code string
-			Body string
-		} `json:"owa.Templates"`
-		Grps []struct {
-			ID  int32
-			SID string
-		} `json:"owa.UserGroups"`
-		SoapUserName string `json:"soap.DBUserName"`
-		SoapUserPass string `json:"soap.DBUserPass"`
-		SoapConnStr  string `json:"soap.DBConnStr"`
-	} `json:"Http.Handlers"`
+	ServiceName      string                `json:"Service.Name"`
+	ServiceDispName  string                `json:"Service.DisplayName"`
+	HTTPPort         int                   `json:"Http.Port"`
+	HTTPDebugPort    int                   `json:"Http.DebugPort"`
+	HTTPReadTimeout  int                   `json:"Http.ReadTimeout"`
+	HTTPWriteTimeout int                   `json:"Http.WriteTimeout"`
+	ShutdownTimeout  int                   `json:"Http.ShutdownTimeout"`
+	ShutdownDrain    int                   `json:"Http.ShutdownDrainDelay"`
+	HTTPSsl          bool                  `json:"Http.SSL"`
+	HTTPSslCert      string                `json:"Http.SSLCert"`
+	HTTPSslKey       string                `json:"Http.SSLKey"`
+	HTTPSslCertFile  string                `json:"Http.SSLCertFile"`
+	HTTPSslKeyFile   string                `json:"Http.SSLKeyFile"`
+	HTTPCertificates []certSource          `json:"Http.SSLCertificates"`
+	HTTPSslMinVer    string                `json:"Http.SSLMinVersion"`
+	HTTPSslCiphers   []string              `json:"Http.SSLCipherSuites"`
+	HTTPDisableHTTP2 bool                  `json:"Http.DisableHTTP2"`
+	HTTPClientAuth   string                `json:"Http.SSLClientAuth"`
+	HTTPClientCA     string                `json:"Http.SSLClientCA"`
+	HTTPLogDir       string                `json:"Http.LogDir"`
+	HTTPLogFormat    string                `json:"Http.LogFormat"`
+	HTTPLogExtra     bool                  `json:"Http.LogExtraFields"`
+	HTTPLogRotation  string                `json:"Http.LogRotation"`
+	HTTPLogMaxSize   int                   `json:"Http.LogMaxSize"`
+	HTTPLogCompress  bool                  `json:"Http.LogCompress"`
+	HTTPLogMaxFiles  int                   `json:"Http.LogMaxFiles"`
+	HTTPLogMaxAge    int                   `json:"Http.LogMaxAge"`
+	HTTPLogNoStdout  bool                  `json:"Http.LogNoStdout"`
+	HTTPRedactParams []string              `json:"Http.RedactParams"`
+	HTTPTrustedProxy []string              `json:"Http.TrustedProxies"`
+	HTTPDebugAllow   []string              `json:"Http.DebugAllowIPs"`
+	HTTPDebugDeny    []string              `json:"Http.DebugDenyIPs"`
+	HTTPProxyProto   bool                  `json:"Http.ProxyProtocol"`
+	HTTPDebugProxy   bool                  `json:"Http.DebugProxyProtocol"`
+	HTTPProxyFrom    []string              `json:"Http.ProxyProtocolFrom"`
+	HTTPReadyReader  bool                  `json:"Http.ReadyRequiresReader"`
+	HTTPUsers        json.RawMessage       `json:"Http.Users"`
+	Handlers         []handlerConfigHolder `json:"Http.Handlers"`
+}
+
+type handlerConfigHolder struct {
+	Path               string `json:"Path"`
+	Type               string `json:"Type"`
+	Host               string `json:"Host"`
+	PathCase           string `json:"PathCase"`
+	RootDir            string `json:"RootDir"`
+	RedirectPath       string `json:"RedirectPath"`
+	SessionIdleTimeout int    `json:"owa.SessionIdleTimeout"`
+	SessionWaitTimeout int    `json:"owa.SessionWaitTimeout"`
+	RequestUserInfo    bool   `json:"owa.ReqUserInfo"`
+	RequestUserRealm   string `json:"owa.ReqUserRealm"`
+	DefUserName        string `json:"owa.DBUserName"`
+	DefUserPass        string `json:"owa.DBUserPass"`
+	BeforeScript       string `json:"owa.BeforeScript"`
+	AfterScript        string `json:"owa.AfterScript"`
+	ParamStoreProc     string `json:"owa.ParamStroreProc"`
+	DocumentTable      string `json:"owa.DocumentTable"`
+	ClientCertUser     string `json:"owa.ClientCertUser"`
+	MaxUserSessions    int    `json:"owa.MaxUserSessions"`
+	Templates          []struct {
+		Code string
+		Body string
+	} `json:"owa.Templates"`
+	Grps []struct {
+		ID  int32
+		SID string
+	} `json:"owa.UserGroups"`
+	SoapUserName string `json:"soap.DBUserName"`
+	SoapUserPass string `json:"soap.DBUserPass"`
+	SoapConnStr  string `json:"soap.DBConnStr"`
+
+	ProxyUpstreams       []string     `json:"proxy.Upstreams"`
+	ProxyStripPath       bool         `json:"proxy.StripPath"`
+	ProxyPathPrefix      string       `json:"proxy.PathPrefix"`
+	ProxyPreserveHost    bool         `json:"proxy.PreserveHost"`
+	ProxyRequestHeaders  []headerRule `json:"proxy.RequestHeaders"`
+	ProxyResponseHeaders []headerRule `json:"proxy.ResponseHeaders"`
+	ProxyTimeout         int          `json:"proxy.Timeout"`
+	ProxyConnectTimeout  int          `json:"proxy.ConnectTimeout"`
+
+	StaticIndexFile      string            `json:"static.IndexFile"`
+	StaticDisableListing bool              `json:"static.DisableListing"`
+	StaticSPAFallback    bool              `json:"static.SPAFallback"`
+	StaticPrecompressed  bool              `json:"static.Precompressed"`
+	StaticCacheRules     []staticCacheRule `json:"static.CacheRules"`
+
+	Compression        bool `json:"Compression"`
+	CompressionLevel   int  `json:"CompressionLevel"`
+	CompressionMinSize int  `json:"CompressionMinSize"`
+
+	RateLimit    float64 `json:"RateLimit"`
+	RateBurst    int     `json:"RateBurst"`
+	RateLimitKey string  `json:"RateLimitKey"`
+
+	AllowIPs      []string `json:"AllowIPs"`
+	DenyIPs       []string `json:"DenyIPs"`
+	AdminAllowIPs []string `json:"owa.AdminAllowIPs"`
+	AdminDenyIPs  []string `json:"owa.AdminDenyIPs"`
+
+	CORS *corsOptions `json:"CORS"`
+
+	// ResponseHeaders - правила изменения заголовков ответа для Redirect, Static, owa_* и SOAP.
+	// Для Proxy используется proxy.ResponseHeaders
+	ResponseHeaders []headerRule `json:"ResponseHeaders"`
 }
 
 const (
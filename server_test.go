@@ -213,7 +213,8 @@ func performRequest(t *testing.T, method, username, password, urlStr, body, resp
 
 	w := httptest.NewRecorder()
 
-	serveHTTP(w, req)
+	// Запрос обрабатывается так же, как основным слушателем, включая запись в лог
+	mainListener.handler.ServeHTTP(w, req)
 
 	if w.Code != responseCode {
 		t.Errorf("Method %s Url \"%s\" Status code should be %v, was %d", method, urlStr, responseCode, w.Code)
@@ -11,6 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func newSoap(pathStr string, userName, userPass, connStr string,
@@ -48,9 +49,21 @@ func newSoap(pathStr string, userName, userPass, connStr string,
 			}
 
 			_, procName := filepath.Split(path.Clean(r.URL.Path))
+			if info := getRequestInfo(r); info != nil {
+				info.procName = procName
+				dbStart := time.Now()
+				defer func() { info.dbDuration = time.Since(dbStart) }()
+			}
 			cur = conn.NewCursor()
 			defer cur.Close()
 
+			// Идентификатор запроса устанавливается в client_identifier и action сессии
+			if requestID := getRequestID(r); requestID != "" {
+				if err = cur.Execute(stmRequestID, []interface{}{requestID, requestID}, nil); err != nil {
+					return nil, errgo.Newf("soap: Error setting request id: %s", err)
+				}
+			}
+
 			inVar, err = cur.NewVariable(0, oracle.ClobVarType, uint(len(buf)))
 			if err != nil {
 				return nil, errgo.Newf("soap: Error prepare variable: %s", err)
@@ -112,4 +125,5 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 	fmt.Fprint(w, msg)
 }
 
+const stmRequestID = `BEGIN dbms_session.set_identifier(:1); dbms_application_info.set_action(:2); END;`
 const stm = `DECLARE t CLOB := EMPTY_CLOB(); BEGIN t := %s(:1); :2 := t; dbms_session.modify_package_state(dbms_session.reinitialize);END;`
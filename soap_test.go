@@ -24,7 +24,8 @@ func performSoapRequest(t *testing.T, method, urlStr string, headers http.Header
 
 	w := httptest.NewRecorder()
 
-	serveHTTP(w, req)
+	// Запрос обрабатывается так же, как основным слушателем, включая запись в лог
+	mainListener.handler.ServeHTTP(w, req)
 
 	if w.Code != responseCode {
 		t.Errorf("Method %s Status code should be %v, was %d", method, responseCode, w.Code)
@@ -0,0 +1,186 @@
+// static
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const defStaticIndexFile = "index.html"
+
+// staticCacheRule - заголовки кеширования для файлов с расширениями Ext.
+// Ext "*" применяется ко всем файлам, для которых не нашлось другого правила
+type staticCacheRule struct {
+	Ext          []string
+	CacheControl string
+	MaxAge       int
+}
+
+type staticOptions struct {
+	IndexFile      string
+	DisableListing bool
+	SPAFallback    bool
+	Precompressed  bool
+	CacheRules     []staticCacheRule
+}
+
+var staticEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+func newStatic(root http.FileSystem, opt staticOptions) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if opt.IndexFile == "" {
+		opt.IndexFile = defStaticIndexFile
+	}
+	cacheRules := make(map[string]staticCacheRule)
+	for _, rule := range opt.CacheRules {
+		for _, ext := range rule.Ext {
+			cacheRules[strings.ToLower(ext)] = rule
+		}
+	}
+	fileServer := http.FileServer(root)
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := path.Clean("/" + p.ByName("filepath"))
+
+		f, err := root.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) && opt.SPAFallback && path.Ext(name) == "" {
+				serveStaticFile(w, r, root, "/"+opt.IndexFile, opt.Precompressed, cacheRules)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		d, err := f.Stat()
+		f.Close()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !d.IsDir() {
+			serveStaticFile(w, r, root, name, opt.Precompressed, cacheRules)
+			return
+		}
+
+		index := path.Join(name, opt.IndexFile)
+		if fi, err := root.Open(index); err == nil {
+			fi.Close()
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+				return
+			}
+			serveStaticFile(w, r, root, index, opt.Precompressed, cacheRules)
+			return
+		}
+		if opt.DisableListing {
+			http.NotFound(w, r)
+			return
+		}
+		// FileServer показывает содержимое каталога только для пути, заканчивающегося на "/"
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = strings.TrimSuffix(name, "/") + "/"
+		}
+		r.URL.Path = name
+		fileServer.ServeHTTP(w, r)
+	}
+}
+
+// serveStaticFile отдает файл name. Если разрешено и клиент принимает сжатые данные,
+// вместо файла отдается его сжатая копия name.br или name.gz
+func serveStaticFile(w http.ResponseWriter, r *http.Request, root http.FileSystem, name string, precompressed bool, cacheRules map[string]staticCacheRule) {
+	ext := strings.ToLower(path.Ext(name))
+
+	if precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, v := range staticEncodings {
+			if !acceptsEncoding(r, v.encoding) {
+				continue
+			}
+			f, err := root.Open(name + v.ext)
+			if err != nil {
+				continue
+			}
+			d, err := f.Stat()
+			if err != nil || d.IsDir() {
+				f.Close()
+				continue
+			}
+			ctype := mime.TypeByExtension(ext)
+			if ctype == "" {
+				ctype = "application/octet-stream"
+			}
+			w.Header().Set("Content-Type", ctype)
+			w.Header().Set("Content-Encoding", v.encoding)
+			setCacheHeaders(w, ext, cacheRules)
+			http.ServeContent(w, r, name, d.ModTime(), f)
+			f.Close()
+			return
+		}
+	}
+
+	f, err := root.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	d, err := f.Stat()
+	if err != nil || d.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	setCacheHeaders(w, ext, cacheRules)
+	http.ServeContent(w, r, name, d.ModTime(), f)
+}
+
+func setCacheHeaders(w http.ResponseWriter, ext string, cacheRules map[string]staticCacheRule) {
+	rule, ok := cacheRules[ext]
+	if !ok {
+		if rule, ok = cacheRules["*"]; !ok {
+			return
+		}
+	}
+	cacheControl := rule.CacheControl
+	if cacheControl == "" && rule.MaxAge > 0 {
+		cacheControl = fmt.Sprintf("public, max-age=%d", rule.MaxAge)
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if rule.MaxAge > 0 {
+		w.Header().Set("Expires", time.Now().Add(time.Duration(rule.MaxAge)*time.Second).UTC().Format(http.TimeFormat))
+	}
+}
+
+// acceptsEncoding проверяет, что клиент указал кодировку в Accept-Encoding с ненулевым q
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(v, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), encoding) {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+// static_test
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"index.html":       "index",
+		"app.js":           "js",
+		"app.js.gz":        "js.gz",
+		"app.js.br":        "js.br",
+		"img/logo.png":     "png",
+		"docs/start.html":  "start",
+		"docs/a/readme.md": "readme",
+	}
+	for name, body := range files {
+		fileName := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		opt          staticOptions
+		url          string
+		encoding     string
+		code         int
+		body         string
+		contentEnc   string
+		cacheControl string
+	}{
+		{staticOptions{}, "/i/app.js", "", http.StatusOK, "js", "", ""},
+		{staticOptions{Precompressed: true}, "/i/app.js", "gzip, deflate", http.StatusOK, "js.gz", "gzip", ""},
+		{staticOptions{Precompressed: true}, "/i/app.js", "gzip, br", http.StatusOK, "js.br", "br", ""},
+		{staticOptions{Precompressed: true}, "/i/app.js", "gzip;q=0", http.StatusOK, "js", "", ""},
+		{staticOptions{CacheRules: []staticCacheRule{{Ext: []string{".js"}, MaxAge: 60}}}, "/i/app.js", "", http.StatusOK, "js", "", "public, max-age=60"},
+		{staticOptions{CacheRules: []staticCacheRule{{Ext: []string{"*"}, CacheControl: "no-cache"}}}, "/i/img/logo.png", "", http.StatusOK, "png", "", "no-cache"},
+		{staticOptions{}, "/i/", "", http.StatusOK, "index", "", ""},
+		{staticOptions{IndexFile: "start.html"}, "/i/docs/", "", http.StatusOK, "start", "", ""},
+		{staticOptions{}, "/i/docs/a/", "", http.StatusOK, "<pre>", "", ""},
+		{staticOptions{DisableListing: true}, "/i/docs/a/", "", http.StatusNotFound, "", "", ""},
+		{staticOptions{}, "/i/users/1", "", http.StatusNotFound, "", "", ""},
+		{staticOptions{SPAFallback: true}, "/i/users/1", "", http.StatusOK, "index", "", ""},
+		{staticOptions{SPAFallback: true}, "/i/absent.js", "", http.StatusNotFound, "", "", ""},
+	}
+	for _, v := range tests {
+		rt := httprouter.New()
+		rt.GET("/i/*filepath", newStatic(http.Dir(dir), v.opt))
+
+		r := httptest.NewRequest("GET", v.url, nil)
+		if v.encoding != "" {
+			r.Header.Set("Accept-Encoding", v.encoding)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s %+v: got status %d, want %d", v.url, v.opt, w.Code, v.code)
+			continue
+		}
+		if v.code != http.StatusOK {
+			continue
+		}
+		body := w.Body.String()
+		if v.body == "<pre>" {
+			if !strings.Contains(body, "readme.md") {
+				t.Errorf("%s %+v: directory listing expected, got \"%s\"", v.url, v.opt, body)
+			}
+		} else if body != v.body {
+			t.Errorf("%s %+v: got \"%s\", want \"%s\"", v.url, v.opt, body, v.body)
+		}
+		if res := w.Header().Get("Content-Encoding"); res != v.contentEnc {
+			t.Errorf("%s %+v: got Content-Encoding \"%s\", want \"%s\"", v.url, v.opt, res, v.contentEnc)
+		}
+		if res := w.Header().Get("Cache-Control"); res != v.cacheControl {
+			t.Errorf("%s %+v: got Cache-Control \"%s\", want \"%s\"", v.url, v.opt, res, v.cacheControl)
+		}
+	}
+}
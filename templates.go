@@ -17,10 +17,14 @@ func responseError(w http.ResponseWriter, templateBody, e string) {
 		return
 	}
 
-	type ErrorInfo struct{ ErrMsg template.HTML }
+	type ErrorInfo struct {
+		ErrMsg    template.HTML
+		RequestID string
+	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err = templ.ExecuteTemplate(w, "error", ErrorInfo{template.HTML(e)})
+	// Идентификатор запроса уже установлен в заголовке ответа
+	err = templ.ExecuteTemplate(w, "error", ErrorInfo{template.HTML(e), w.Header().Get(requestIDHeader)})
 
 	if err != nil {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
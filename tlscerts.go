@@ -0,0 +1,308 @@
+// tlscerts
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckInterval - период проверки изменения файлов сертификатов
+const certCheckInterval = 10 * time.Second
+
+// certSource - сертификат и ключ, заданные в конфигурации в виде PEM или путями к файлам
+type certSource struct {
+	Cert     string
+	Key      string
+	CertFile string
+	KeyFile  string
+}
+
+func (s certSource) files() []string {
+	res := make([]string, 0, 2)
+	if s.CertFile != "" {
+		res = append(res, s.CertFile)
+	}
+	if s.KeyFile != "" {
+		res = append(res, s.KeyFile)
+	}
+	return res
+}
+
+func (s certSource) load() (*tls.Certificate, error) {
+	certPEM, keyPEM := []byte(s.Cert), []byte(s.Key)
+	var err error
+	if s.CertFile != "" {
+		if certPEM, err = ioutil.ReadFile(s.CertFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.KeyFile != "" {
+		if keyPEM, err = ioutil.ReadFile(s.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	// Leaf нужен для выбора сертификата по SNI и для передачи сведений о сертификате в CGI окружение
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// certStore - сертификаты сервера. Первый выдается, если имя из SNI не подошло ни к одному сертификату
+type certStore struct {
+	certs  []*tls.Certificate
+	byName map[string]*tls.Certificate
+}
+
+func newCertStore(certs []*tls.Certificate) *certStore {
+	s := &certStore{certs: certs, byName: make(map[string]*tls.Certificate)}
+	for _, cert := range certs {
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := s.byName[name]; !ok {
+				s.byName[name] = cert
+			}
+		}
+	}
+	return s
+}
+
+func (s *certStore) get(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+	return s.certs[0]
+}
+
+// serverCertSources возвращает сертификаты сервера: основной (Http.SSLCert/Http.SSLKey или файлы)
+// и дополнительные, выбираемые по SNI
+func serverCertSources(cert, key, certFile, keyFile string, extra []certSource) []certSource {
+	res := make([]certSource, 0, len(extra)+1)
+	if cert != "" || key != "" || certFile != "" || keyFile != "" {
+		res = append(res, certSource{Cert: cert, Key: key, CertFile: certFile, KeyFile: keyFile})
+	}
+	return append(res, extra...)
+}
+
+var (
+	certLock    sync.RWMutex
+	certs       *certStore
+	certSources []certSource
+	certStamps  map[string]time.Time
+	certWatcher sync.Once
+)
+
+func loadCertificates(sources []certSource) ([]*tls.Certificate, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("certificate is not set")
+	}
+	res := make([]*tls.Certificate, 0, len(sources))
+	for k, s := range sources {
+		cert, err := s.load()
+		if err != nil {
+			return nil, fmt.Errorf("certificate #%d: %s", k+1, err)
+		}
+		res = append(res, cert)
+	}
+	return res, nil
+}
+
+func fileStamps(sources []certSource) map[string]time.Time {
+	res := make(map[string]time.Time)
+	for _, s := range sources {
+		for _, f := range s.files() {
+			if fi, err := os.Stat(f); err == nil {
+				res[f] = fi.ModTime()
+			}
+		}
+	}
+	return res
+}
+
+// setCertificates заменяет сертификаты, выдаваемые при новых TLS соединениях.
+// Сертификаты, заданные файлами, перечитываются при изменении файлов
+func setCertificates(sources []certSource) error {
+	stamps := fileStamps(sources)
+	loaded, err := loadCertificates(sources)
+	if err != nil {
+		return err
+	}
+	certLock.Lock()
+	certs = newCertStore(loaded)
+	certSources = sources
+	certStamps = stamps
+	certLock.Unlock()
+
+	certWatcher.Do(func() {
+		go func() {
+			for range time.Tick(certCheckInterval) {
+				reloadCertificates()
+			}
+		}()
+	})
+	return nil
+}
+
+// reloadCertificates перечитывает сертификаты, если изменились их файлы
+func reloadCertificates() {
+	certLock.RLock()
+	sources, stamps := certSources, certStamps
+	certLock.RUnlock()
+
+	newStamps := fileStamps(sources)
+	changed := len(newStamps) != len(stamps)
+	for f, t := range newStamps {
+		if !stamps[f].Equal(t) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	loaded, err := loadCertificates(sources)
+	if err != nil {
+		// Файлы могут быть записаны не полностью, повторим при следующей проверке
+		logError("Error reloading certificates: ", err)
+		return
+	}
+	certLock.Lock()
+	defer certLock.Unlock()
+	if !sameSources(certSources, sources) {
+		// Сертификаты были заменены из конфигурации
+		return
+	}
+	certs = newCertStore(loaded)
+	certStamps = newStamps
+	logInfof("Certificates are reloaded\n")
+}
+
+func sameSources(a, b []certSource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certLock.RLock()
+	defer certLock.RUnlock()
+	if certs == nil {
+		return nil, errors.New("certificate is not set")
+	}
+	serverName := ""
+	if hello != nil {
+		serverName = hello.ServerName
+	}
+	return certs.get(serverName), nil
+}
+
+var (
+	tlsOptionsLock sync.RWMutex
+	tlsMinVersion  uint16
+	tlsCiphers     []uint16
+	tlsHTTP2       = true
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
+func parseTLSOptions(minVersion string, ciphers []string) (uint16, []uint16, error) {
+	var version uint16
+	if minVersion != "" {
+		name := strings.Replace(strings.ToUpper(minVersion), "V", "", 1)
+		if !strings.HasPrefix(name, "TLS") {
+			name = "TLS" + name
+		}
+		v, ok := tlsVersions[name]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown TLS version \"%s\"", minVersion)
+		}
+		version = v
+	}
+	if len(ciphers) == 0 {
+		return version, nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, c := range tls.CipherSuites() {
+		known[c.Name] = c.ID
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		known[c.Name] = c.ID
+	}
+	ids := make([]uint16, 0, len(ciphers))
+	for _, name := range ciphers {
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown cipher suite \"%s\"", name)
+		}
+		ids = append(ids, id)
+	}
+	return version, ids, nil
+}
+
+// setTLSOptions задает минимальную версию TLS, наборы шифров и поддержку HTTP/2 для новых соединений
+func setTLSOptions(minVersion string, ciphers []string, http2 bool) error {
+	version, ids, err := parseTLSOptions(minVersion, ciphers)
+	if err != nil {
+		return err
+	}
+	tlsOptionsLock.Lock()
+	tlsMinVersion, tlsCiphers, tlsHTTP2 = version, ids, http2
+	tlsOptionsLock.Unlock()
+	return nil
+}
+
+// tlsConfigForClient возвращает для соединения конфигурацию base, дополненную текущими параметрами TLS
+// и проверкой клиентского сертификата
+func tlsConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+
+		tlsOptionsLock.RLock()
+		c.MinVersion = tlsMinVersion
+		c.CipherSuites = tlsCiphers
+		if tlsHTTP2 {
+			c.NextProtos = []string{"h2", "http/1.1"}
+		} else {
+			c.NextProtos = []string{"http/1.1"}
+		}
+		tlsOptionsLock.RUnlock()
+
+		clientAuthLock.RLock()
+		c.ClientAuth = clientAuth
+		c.ClientCAs = clientCAs
+		clientAuthLock.RUnlock()
+		return c, nil
+	}
+}
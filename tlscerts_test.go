@@ -0,0 +1,168 @@
+// tlscerts_test
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iplsgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caPEM, _, caCert, caKey := makeTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	makeServerCert := func(serial int64, cn string, names ...string) (string, string) {
+		certPEM, keyPEM, _, _ := makeTestCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			DNSNames:     names,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, caCert, caKey)
+		return certPEM, keyPEM
+	}
+	writeFiles := func(certPEM, keyPEM string, stamp time.Time) {
+		for f, data := range map[string]string{"a.pem": certPEM, "a.key": keyPEM} {
+			name := filepath.Join(dir, f)
+			if err := ioutil.WriteFile(name, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(name, stamp, stamp); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	aPEM, aKey := makeServerCert(2, "a", "a.example.com")
+	writeFiles(aPEM, aKey, time.Now().Add(-time.Minute))
+	bPEM, bKey := makeServerCert(3, "b", "*.b.example.com")
+
+	if err := setCertificates(serverCertSources("", "", filepath.Join(dir, "a.pem"), filepath.Join(dir, "a.key"),
+		[]certSource{{Cert: bPEM, Key: bKey}})); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		certLock.Lock()
+		certs, certSources = nil, nil
+		certLock.Unlock()
+	}()
+	if err := setTLSOptions("TLS1.2", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	defer setTLSOptions("", nil, true)
+
+	tlsConfig := &tls.Config{GetCertificate: getCertificate}
+	tlsConfig.GetConfigForClient = tlsConfigForClient(tlsConfig)
+	s := &httpListener{
+		name:      "Test",
+		tlsConfig: tlsConfig,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	port := freePort(t)
+	if err := s.apply(port, true, time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer s.apply(0, false, 0, 0)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(caPEM))
+	// get возвращает CN сертификата сервера и протокол ответа
+	get := func(serverName string) (string, string) {
+		client := http.Client{
+			Timeout: time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: serverName},
+				ForceAttemptHTTP2: true,
+			},
+		}
+		resp, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/")
+		if err != nil {
+			t.Fatalf("%s: %s", serverName, err)
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, string(buf)
+	}
+
+	var tests = []struct {
+		serverName string
+		cn         string
+	}{
+		{"a.example.com", "a"},
+		{"c.b.example.com", "b"},
+	}
+	for _, v := range tests {
+		if cn, proto := get(v.serverName); cn != v.cn || proto != "HTTP/2.0" {
+			t.Errorf("%s: got \"%s\" over %s, want \"%s\" over HTTP/2.0", v.serverName, cn, proto, v.cn)
+		}
+	}
+	if cert, _ := getCertificate(&tls.ClientHelloInfo{ServerName: "unknown.example.com"}); cert.Leaf.Subject.CommonName != "a" {
+		t.Errorf("Unknown name: got \"%s\", want the first certificate", cert.Leaf.Subject.CommonName)
+	}
+
+	// Замена файлов сертификата
+	a2PEM, a2Key := makeServerCert(4, "a2", "a.example.com")
+	writeFiles(a2PEM, a2Key, time.Now())
+	reloadCertificates()
+	if cn, _ := get("a.example.com"); cn != "a2" {
+		t.Errorf("After reload: got \"%s\", want \"a2\"", cn)
+	}
+
+	// Недописанный файл не заменяет загруженный сертификат
+	writeFiles(a2PEM[:len(a2PEM)/2], a2Key, time.Now().Add(time.Minute))
+	reloadCertificates()
+	if cn, _ := get("a.example.com"); cn != "a2" {
+		t.Errorf("After broken reload: got \"%s\", want \"a2\"", cn)
+	}
+
+	setTLSOptions("", nil, false)
+	if _, proto := get("a.example.com"); proto != "HTTP/1.1" {
+		t.Errorf("HTTP/2 disabled: got %s, want HTTP/1.1", proto)
+	}
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	var tests = []struct {
+		version string
+		ciphers []string
+		want    uint16
+		wantErr bool
+	}{
+		{"", nil, 0, false},
+		{"TLS1.2", nil, tls.VersionTLS12, false},
+		{"TLSv1.3", nil, tls.VersionTLS13, false},
+		{"1.1", nil, tls.VersionTLS11, false},
+		{"SSL3", nil, 0, true},
+		{"TLS1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, tls.VersionTLS12, false},
+		{"TLS1.2", []string{"TLS_UNKNOWN"}, 0, true},
+	}
+	for _, v := range tests {
+		version, ids, err := parseTLSOptions(v.version, v.ciphers)
+		if (err != nil) != v.wantErr {
+			t.Errorf("%s %v: unexpected error state: %v", v.version, v.ciphers, err)
+			continue
+		}
+		if err == nil && (version != v.want || len(ids) != len(v.ciphers)) {
+			t.Errorf("%s %v: got %d %v", v.version, v.ciphers, version, ids)
+		}
+	}
+}
@@ -8,6 +8,12 @@ import (
 	"sync"
 )
 
+type userConfigHolder struct {
+	Name      string
+	IsSpecial bool
+	GRP_ID    int32
+}
+
 type userInfo struct {
 	IsSpecial bool
 	GrpID     int32
@@ -72,12 +78,7 @@ func updateUsers(users []byte) {
 				return
 			}
 
-			type _tUser struct {
-				Name      string
-				IsSpecial bool
-				GRP_ID    int32
-			}
-			var t = []_tUser{}
+			var t = []userConfigHolder{}
 			if err := json.Unmarshal(users, &t); err != nil {
 				logError(err)
 			}
@@ -0,0 +1,163 @@
+// vhost
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Политика регистра пути запроса:
+// lower - путь приводится к нижнему регистру (по умолчанию; Proxy передает upstream исходный путь),
+// preserve - поиск маршрута с учетом регистра, путь не изменяется,
+// insensitive - поиск маршрута без учета регистра, обработчик получает исходный путь
+const (
+	pathCaseLower       = "lower"
+	pathCasePreserve    = "preserve"
+	pathCaseInsensitive = "insensitive"
+)
+
+// vhostRoutes - маршруты виртуального хоста.
+// В exact регистрируются обработчики с политикой preserve, в lower - все остальные
+type vhostRoutes struct {
+	exact *httprouter.Router
+	lower *httprouter.Router
+}
+
+type vhostWildcard struct {
+	suffix string
+	routes *vhostRoutes
+}
+
+// vhostRouter выбирает роутер по заголовку Host запроса.
+// Host обработчика может быть именем хоста ("app.example.com") или шаблоном ("*.example.com").
+// Запросы к хостам, для которых нет обработчиков, обслуживает роутер по умолчанию
+type vhostRouter struct {
+	def       *vhostRoutes
+	hosts     map[string]*vhostRoutes
+	wildcards []vhostWildcard
+}
+
+func newVhostRoutes() *vhostRoutes {
+	return &vhostRoutes{exact: httprouter.New(), lower: httprouter.New()}
+}
+
+func newVhostRouter() *vhostRouter {
+	return &vhostRouter{
+		def:   newVhostRoutes(),
+		hosts: make(map[string]*vhostRoutes),
+	}
+}
+
+func validPathCase(pathCase string) bool {
+	switch pathCase {
+	case "", pathCaseLower, pathCasePreserve, pathCaseInsensitive:
+		return true
+	}
+	return false
+}
+
+// casePath возвращает путь обработчика в том виде, в котором он регистрируется в роутере
+func casePath(upath, pathCase string) string {
+	if pathCase == pathCasePreserve {
+		return upath
+	}
+	return strings.ToLower(upath)
+}
+
+// preservePathCase возвращает обработчику исходный путь запроса.
+// Все маршруты заканчиваются параметром вида "/*name", поэтому значения параметров - окончания пути
+func preservePathCase(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		// Если при смене регистра изменилась длина строки, оставляем путь в нижнем регистре
+		if info := getRequestInfo(r); info != nil && info.originalPath != "" && len(info.originalPath) == len(r.URL.Path) {
+			orig := info.originalPath
+			for k := range p {
+				p[k].Value = orig[len(orig)-len(p[k].Value):]
+			}
+			r.URL.Path = orig
+		}
+		handle(w, r, p)
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// routerFor возвращает роутер для значений Host и PathCase обработчика, при необходимости создавая его
+func (v *vhostRouter) routerFor(host, pathCase string) *httprouter.Router {
+	routes := v.routesFor(host)
+	if pathCase == pathCasePreserve {
+		return routes.exact
+	}
+	return routes.lower
+}
+
+func (v *vhostRouter) routesFor(host string) *vhostRoutes {
+	host = normalizeHost(host)
+	if host == "" {
+		return v.def
+	}
+	if strings.HasPrefix(host, "*.") {
+		suffix := host[1:]
+		for _, w := range v.wildcards {
+			if w.suffix == suffix {
+				return w.routes
+			}
+		}
+		routes := newVhostRoutes()
+		v.wildcards = append(v.wildcards, vhostWildcard{suffix, routes})
+		// Более конкретный шаблон проверяется первым
+		sort.SliceStable(v.wildcards, func(i, j int) bool {
+			return len(v.wildcards[i].suffix) > len(v.wildcards[j].suffix)
+		})
+		return routes
+	}
+	routes, ok := v.hosts[host]
+	if !ok {
+		routes = newVhostRoutes()
+		v.hosts[host] = routes
+	}
+	return routes
+}
+
+// match возвращает маршруты для запроса и имя виртуального хоста ("" для роутера по умолчанию)
+func (v *vhostRouter) match(r *http.Request) (*vhostRoutes, string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = normalizeHost(host)
+	if routes, ok := v.hosts[host]; ok {
+		return routes, host
+	}
+	for _, w := range v.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.routes, host
+		}
+	}
+	return v.def, ""
+}
+
+func (v *vhostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	info := getRequestInfo(r)
+	if info == nil {
+		info = &requestInfo{}
+		r = withRequestInfo(r, info)
+	}
+	routes, host := v.match(r)
+	info.vhost = host
+	if handle, p, _ := routes.exact.Lookup(r.Method, r.URL.Path); handle != nil {
+		handle(w, r, p)
+		return
+	}
+	if lower := strings.ToLower(r.URL.Path); lower != r.URL.Path {
+		info.originalPath = r.URL.Path
+		r.URL.Path = lower
+	}
+	routes.lower.ServeHTTP(w, r)
+}
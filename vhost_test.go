@@ -0,0 +1,83 @@
+// vhost_test
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestVhostRouter(t *testing.T) {
+	vr := newVhostRouter()
+	for _, host := range []string{"", "App.Example.com", "*.example.com", "*.b.example.com"} {
+		name := host
+		if name == "" {
+			name = "default"
+		}
+		vr.routerFor(host, "").GET("/x", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Write([]byte(name + " " + makeEnvParams(r, "", "", "")["SERVER_NAME"]))
+		})
+	}
+
+	var tests = []struct {
+		host string
+		res  string
+	}{
+		{"app.example.com", "App.Example.com app.example.com"},
+		{"APP.example.com:8080", "App.Example.com app.example.com"},
+		{"c.example.com", "*.example.com c.example.com"},
+		{"a.b.example.com", "*.b.example.com a.b.example.com"},
+		{"example.com", "default example.com"},
+		{"other.org:80", "default other.org"},
+	}
+	for _, v := range tests {
+		r := httptest.NewRequest("GET", "/x", nil)
+		r.Host = v.host
+		w := httptest.NewRecorder()
+		vr.ServeHTTP(w, r)
+		if res := w.Body.String(); res != v.res {
+			t.Errorf("%s: got \"%s\", want \"%s\"", v.host, res, v.res)
+		}
+	}
+}
+
+func TestPathCase(t *testing.T) {
+	vr := newVhostRouter()
+	for _, v := range []struct{ path, pathCase string }{
+		{"/Lower", pathCaseLower},
+		{"/Exact", pathCasePreserve},
+		{"/Insensitive", pathCaseInsensitive},
+	} {
+		handle := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			w.Write([]byte(r.URL.Path + " " + p.ByName("proc")))
+		}
+		if v.pathCase == pathCaseInsensitive {
+			handle = preservePathCase(handle)
+		}
+		vr.routerFor("", v.pathCase).GET(casePath(v.path, v.pathCase)+"/*proc", handle)
+	}
+
+	var tests = []struct {
+		url  string
+		code int
+		res  string
+	}{
+		{"/LOWER/Pkg.Proc", http.StatusOK, "/lower/pkg.proc /pkg.proc"},
+		{"/Exact/Pkg.Proc", http.StatusOK, "/Exact/Pkg.Proc /Pkg.Proc"},
+		{"/exact/Pkg.Proc", http.StatusNotFound, ""},
+		{"/INSENSITIVE/Res/Item/1", http.StatusOK, "/INSENSITIVE/Res/Item/1 /Res/Item/1"},
+	}
+	for _, v := range tests {
+		w := httptest.NewRecorder()
+		vr.ServeHTTP(w, httptest.NewRequest("GET", v.url, nil))
+		if w.Code != v.code {
+			t.Errorf("%s: got status %d, want %d", v.url, w.Code, v.code)
+			continue
+		}
+		if v.code == http.StatusOK && w.Body.String() != v.res {
+			t.Errorf("%s: got \"%s\", want \"%s\"", v.url, w.Body.String(), v.res)
+		}
+	}
+}